@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// benchLines is the number of distinct synthetic lines cycled through, so
+// generating them does not dominate the results.
+const benchLines = 1024
+
+// runBench appends synthetic lines the way lines read from stdin are, to an
+// output configured by the usual flags, and prints the throughput, the
+// latency and allocations of appending and the time spent rotating and
+// compressing as a logfmt line.
+func runBench(args []string) int {
+	duration := flag.Duration("bench-duration", 10*time.Second, "How long to append lines")
+	rate := flag.Int("bench-rate", 0, "Lines per second to append, 0 for as many as possible")
+	lineSize := sizeVar("bench-line-size", 200, "`Size` of each line in bytes")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate bench [FLAGS]\n\t\tmeasures how fast lines can be written with FLAGS\n")
+		fmt.Fprintf(os.Stderr, "\tWithout --output the lines go to a temporary directory removed afterwards.\n\nFLAGS:\n")
+		flag.PrintDefaults()
+	}
+	if err := applyEnvironment(flag.CommandLine); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	flag.CommandLine.Parse(args)
+	if err := setupLogging(*logLevelName, *logFormat, *logFile); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+
+	output := *outputFile
+	outputGiven := false
+	flag.Visit(func(f *flag.Flag) { outputGiven = outputGiven || f.Name == "output" })
+	if !outputGiven {
+		dir, err := ioutil.TempDir("", "stdin-rotate-bench")
+		if err != nil {
+			log.Println("ERROR: cannot create temporary directory:", err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		output = path.Join(dir, "bench.log")
+	}
+
+	opts := appenderOptions(output)
+	opts.Metrics = rotate.NewMetrics()
+	a, err := rotate.New(opts)
+	if err != nil {
+		log.Println("ERROR:", err)
+		return 1
+	}
+
+	p := &pipeline{appender: a, metrics: opts.Metrics}
+	lines := benchmarkLines(*lineSize)
+	var latencies []time.Duration
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	count := 0
+	for time.Since(start) < *duration {
+		if *rate > 0 {
+			due := int(time.Since(start).Seconds() * float64(*rate))
+			if count >= due {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+		}
+		lineStart := time.Now()
+		p.AppendBytes(lines[count%len(lines)])
+		latencies = append(latencies, time.Since(lineStart))
+		count++
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	closeStart := time.Now()
+	if err := a.Close(); err != nil {
+		log.Println("ERROR: cannot close output:", err)
+	}
+	closing := time.Since(closeStart)
+
+	counters, timings := opts.Metrics.Snapshot()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("lines=%d bytes=%d duration=%s lines_per_sec=%.1f mb_per_sec=%.2f", count, counters["bytes"], elapsed.Round(time.Millisecond),
+		float64(count)/elapsed.Seconds(), float64(counters["bytes"])/elapsed.Seconds()/(1<<20))
+	fmt.Printf(" append_p50=%s append_p99=%s append_max=%s", percentile(latencies, 0.5), percentile(latencies, 0.99), percentile(latencies, 1))
+	if count > 0 {
+		// Also counts what growing the latencies takes, a few per million lines.
+		fmt.Printf(" allocs_per_line=%.2f", float64(after.Mallocs-before.Mallocs)/float64(count))
+	}
+	for _, timing := range []struct{ name, count string }{{"rotate", "rotations"}, {"compress", "compressions"}} {
+		t := timings[timing.name]
+		average := time.Duration(0)
+		if t.Count > 0 {
+			average = t.Total / time.Duration(t.Count)
+		}
+		fmt.Printf(" %s=%d %s_avg=%s %s_total=%s", timing.count, t.Count, timing.name, average.Round(time.Microsecond),
+			timing.name, t.Total.Round(time.Microsecond))
+	}
+	fmt.Printf(" close=%s\n", closing.Round(time.Microsecond))
+	return 0
+}
+
+// benchmarkLines returns lines of size bytes made of words, so they compress
+// roughly like log lines do.
+func benchmarkLines(size int) [][]byte {
+	words := []string{"GET", "POST", "/api/v1/users", "200", "404", "took", "ms", "user_id=", "request", "INFO", "WARN", "session", "cache", "miss", "hit"}
+	random := rand.New(rand.NewSource(1))
+	lines := make([][]byte, benchLines)
+	for i := range lines {
+		line := []byte("line " + strconv.Itoa(i))
+		for len(line) < size {
+			line = append(line, ' ')
+			line = append(line, words[random.Intn(len(words))]...)
+			line = strconv.AppendInt(line, random.Int63n(100000), 10)
+		}
+		lines[i] = line[:size]
+	}
+	return lines
+}
+
+// percentile returns the duration below which the share p of the sorted
+// durations are.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(float64(len(sorted)-1) * p)
+	return sorted[index]
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// timeFlag is a point in time given as a timestamp or as a duration before
+// now.
+type timeFlag struct {
+	t time.Time
+}
+
+var timeFlagLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func (f *timeFlag) String() string {
+	if f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(value string) error {
+	if d, err := time.ParseDuration(value); err == nil {
+		f.t = time.Now().Add(-d)
+		return nil
+	}
+	for _, layout := range timeFlagLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			f.t = t
+			return nil
+		}
+	}
+	return fmt.Errorf("neither a timestamp like 2006-01-02 15:04 nor a duration")
+}
+
+// runCat writes the output and its archives to stdout oldest first, so the
+// lines come out as they were read. --since and --until select the files by
+// the times of rotation in the archive names, so they bound whole files and
+// not single lines.
+func runCat(args []string) int {
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+	output := fs.String("output", "./output.log", "Output file to print along with its archives")
+	var since, until timeFlag
+	fs.Var(&since, "since", "Skip the archives rotated before this `time`, a timestamp like 2006-01-02 15:04 or a duration before now like 2h")
+	fs.Var(&until, "until", "Skip the files started after this `time`, a timestamp like 2006-01-02 15:04 or a duration before now like 2h")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate cat [FLAGS]\n\t\tprints --output and its archives oldest first, decompressing them\n\nFLAGS:\n")
+		fs.PrintDefaults()
+	}
+	if args = parseSubcommand(fs, args); len(args) != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	files, err := outputFiles(*output)
+	if err != nil {
+		log.Println("ERROR:", err)
+		return 1
+	}
+
+	status := 0
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+	var started time.Time
+	for _, fileName := range files {
+		// A file holds the lines from the rotation of the previous one
+		// until its own, the live file until now.
+		ended, ok := rotate.ArchiveTime(fileName)
+		if !ok {
+			ended = time.Now()
+		}
+		skip := !since.t.IsZero() && ended.Before(since.t) || !until.t.IsZero() && started.After(until.t)
+		started = ended
+		if skip {
+			continue
+		}
+
+		f, err := rotate.OpenArchive(fileName)
+		if err != nil {
+			log.Println("ERROR:", err)
+			status = 1
+			continue
+		}
+		if _, err := io.Copy(stdout, f); err != nil {
+			log.Println("ERROR: cannot read", fileName+":", err)
+			status = 1
+		}
+		f.Close()
+	}
+	if err := stdout.Flush(); err != nil {
+		log.Println("ERROR: cannot write to stdout:", err)
+		status = 1
+	}
+	return status
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// Exit codes of --check.
+const (
+	checkOK          = 0
+	checkInvalid     = 1
+	checkEnvironment = 2
+)
+
+// runCheck validates the configuration without touching stdin or the
+// output file. Every problem is reported; the exit code is checkInvalid if
+// any flag is invalid and checkEnvironment if the flags are fine but the
+// host is not, e.g. the output directory is not writable.
+func runCheck() int {
+	invalid, environment := checkConfig()
+	for _, problem := range append(invalid, environment...) {
+		fmt.Fprintln(os.Stderr, "ERROR:", problem)
+	}
+	switch {
+	case len(invalid) > 0:
+		return checkInvalid
+	case len(environment) > 0:
+		return checkEnvironment
+	}
+	fmt.Fprintln(os.Stderr, "configuration OK")
+	return checkOK
+}
+
+// checkConfig returns the invalid flags and the problems of the host with
+// them that --check reports.
+func checkConfig() (invalid, environment []string) {
+
+	if *maxFiles < -1 {
+		invalid = append(invalid, "--max-files must be -1 or more")
+	}
+	if *maxFileSize <= 0 {
+		invalid = append(invalid, "--max-size must be positive")
+	}
+	if _, err := parseLogLevel(*logLevelName); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if *onError != "continue" && *onError != "exit" {
+		invalid = append(invalid, fmt.Sprintf("unknown --on-error policy %q", *onError))
+	}
+	if _, ok := queuePolicies[*compressPolicy]; !ok {
+		invalid = append(invalid, fmt.Sprintf("unknown --compress-queue-policy %q", *compressPolicy))
+	}
+	if *compressWindow != "" {
+		if _, err := rotate.ParseWindow(*compressWindow); err != nil {
+			invalid = append(invalid, fmt.Sprintf("invalid --compress-window: %v", err))
+		}
+	}
+	if _, ok := symlinkPolicies[*outputSymlink]; !ok {
+		invalid = append(invalid, fmt.Sprintf("unknown --output-symlink policy %q", *outputSymlink))
+	}
+	if _, ok := retentionPolicies[*retentionMode]; !ok {
+		invalid = append(invalid, fmt.Sprintf("unknown --retention-policy %q", *retentionMode))
+	}
+	if _, ok := controlMetrics[*controlChars]; !ok && *controlChars != "keep" {
+		invalid = append(invalid, fmt.Sprintf("unknown --control-chars policy %q", *controlChars))
+	}
+	if err := checkInputCompression(); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if *onEOF != "exit" && *onEOF != "wait" && *onEOF != "rotate" {
+		invalid = append(invalid, fmt.Sprintf("unknown --on-eof policy %q", *onEOF))
+	}
+	if *truncStartMode != "archive" && *truncStartMode != "discard" {
+		invalid = append(invalid, fmt.Sprintf("unknown --truncate-on-start-mode %q", *truncStartMode))
+	}
+	if *truncOnStart && *truncStartMode == "discard" && *rotateOnStart {
+		invalid = append(invalid, "--truncate-on-start-mode discard cannot be used with --rotate-on-start")
+	}
+	if *onEOF == "wait" && flag.NArg() > 0 {
+		invalid = append(invalid, "--on-eof wait cannot be used with a command")
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		invalid = append(invalid, fmt.Sprintf("unknown log format %q", *logFormat))
+	}
+	if _, ok := severities[*syslogMinLevel]; !ok && *syslogMinLevel != "" {
+		invalid = append(invalid, fmt.Sprintf("unknown --syslog-min-level %q", *syslogMinLevel))
+	}
+	if *journaldPrio < 0 || *journaldPrio > 7 {
+		invalid = append(invalid, "--journald-priority must be between 0 and 7")
+	}
+	for _, name := range []string{"syslog-regexp", "kafka-regexp", "journald-regexp", "gelf-regexp", "flush-on-regexp"} {
+		if _, err := regexp.Compile(flag.Lookup(name).Value.String()); err != nil {
+			invalid = append(invalid, fmt.Sprintf("--%s: %v", name, err))
+		}
+	}
+	if *jsonInvalid != "" && !*jsonCompact && !*jsonSortKeys {
+		invalid = append(invalid, "--json-invalid requires --json-compact or --json-sort-keys")
+	}
+	if *encryptLive && *encryptRcpt == "" {
+		invalid = append(invalid, "--encrypt-live requires --encrypt-recipient")
+	}
+	if *encryptLive && *rotateCopyTrunc {
+		invalid = append(invalid, "--encrypt-live cannot be used with --rotate-copytruncate")
+	}
+	if *directIO && (*encryptLive || *rotateCopyTrunc) {
+		invalid = append(invalid, "--direct-io cannot be used with --encrypt-live or --rotate-copytruncate")
+	}
+	if err := checkSharedOutput(); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if err := checkSeccomp(); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if err := checkNoFile(); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if err := checkArchiveNumbered(); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if err := checkEncryptRecipients(); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if _, err := parseRoutes(*routeFiles); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if *gelfProtocol != "udp" && *gelfProtocol != "tcp" {
+		invalid = append(invalid, fmt.Sprintf("unknown --gelf-protocol %q", *gelfProtocol))
+	}
+	if *forwardBuffer <= 0 {
+		invalid = append(invalid, "--forward-tcp-buffer must be positive")
+	}
+	if err := checkHTTPFlags(); err != nil {
+		invalid = append(invalid, err.Error())
+	}
+	if *kafkaBrokers != "" && *kafkaTopic == "" {
+		invalid = append(invalid, "--kafka-topic is required with --kafka-brokers")
+	}
+
+	if !*noFile {
+		if err := checkWritable(path.Dir(*outputFile)); err != nil {
+			environment = append(environment, fmt.Sprintf("output directory is not writable: %v", err))
+		}
+		if !*nfsSafe {
+			if err := checkLock(*outputFile); err != nil {
+				environment = append(environment, err.Error())
+			}
+		}
+	}
+	if *rotateFIFO != "" {
+		if info, err := os.Stat(*rotateFIFO); err == nil && info.Mode()&os.ModeNamedPipe == 0 {
+			environment = append(environment, fmt.Sprintf("--rotate-fifo %s is not a named pipe", *rotateFIFO))
+		}
+	}
+	if *syslogTarget != "" {
+		if _, err := net.ResolveUDPAddr("udp", *syslogTarget); err != nil {
+			environment = append(environment, fmt.Sprintf("cannot resolve syslog target: %v", err))
+		}
+	}
+	if *statsdTarget != "" {
+		if _, err := net.ResolveUDPAddr("udp", *statsdTarget); err != nil {
+			environment = append(environment, fmt.Sprintf("cannot resolve statsd target: %v", err))
+		}
+	}
+	if *kafkaBrokers != "" {
+		for _, broker := range strings.Split(*kafkaBrokers, ",") {
+			if _, err := net.ResolveTCPAddr("tcp", broker); err != nil {
+				environment = append(environment, fmt.Sprintf("cannot resolve kafka broker: %v", err))
+			}
+		}
+	}
+	if *forwardTCP != "" {
+		if _, err := net.ResolveTCPAddr("tcp", *forwardTCP); err != nil {
+			environment = append(environment, fmt.Sprintf("cannot resolve forward target: %v", err))
+		}
+	}
+	if *fluentdTarget != "" {
+		if _, err := net.ResolveTCPAddr("tcp", *fluentdTarget); err != nil {
+			environment = append(environment, fmt.Sprintf("cannot resolve fluentd target: %v", err))
+		}
+	}
+	if u, err := url.Parse(*httpTarget); *httpTarget != "" && err == nil {
+		if _, err := net.LookupHost(u.Hostname()); err != nil {
+			environment = append(environment, fmt.Sprintf("cannot resolve http target: %v", err))
+		}
+	}
+	if *journald {
+		if err := checkJournal(); err != nil {
+			environment = append(environment, err.Error())
+		}
+	}
+	if *encryptRcpt != "" {
+		binary := "gpg"
+		if strings.HasPrefix(*encryptRcpt, "age1") {
+			binary = "age"
+		}
+		if _, err := exec.LookPath(binary); err != nil {
+			environment = append(environment, fmt.Sprintf("cannot encrypt archives: %v", err))
+		}
+	}
+
+	if *inputCompress == "zstd" {
+		if _, err := exec.LookPath("zstd"); err != nil {
+			environment = append(environment, fmt.Sprintf("cannot decompress zstd input: %v", err))
+		}
+	}
+
+	return invalid, environment
+}
+
+// checkWritable creates and removes a temporary file in dir.
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".stdin-rotate-check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
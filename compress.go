@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// runCompress compresses and encrypts the archives in a directory that are
+// not yet as the flags configure, of whatever outputs they were rotated
+// from, e.g. after restoring a backup or a crash of an older version.
+func runCompress(args []string) int {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate compress DIR [FLAGS]\n\t\tcompresses the archives in DIR not yet compressed as FLAGS configure\n")
+		fmt.Fprintf(os.Stderr, "\tOnly --gzip, --encrypt-recipient, --checksum, --keep-uncompressed and the flags about them apply.\n\nFLAGS:\n")
+		flag.PrintDefaults()
+	}
+	args = parseArchiveCommand(args)
+	if len(args) != 1 {
+		flag.Usage()
+		return 2
+	}
+	if !*compressOld && *encryptRcpt == "" {
+		log.Fatalln("ERROR: compress requires --gzip or --encrypt-recipient")
+	}
+
+	files, err := rotate.ArchivedFiles(args[0], *archiveNumbers)
+	if err != nil {
+		log.Println("ERROR:", err)
+		return 1
+	}
+	status := 0
+	for _, file := range files {
+		lock, err := lockArchives(file)
+		if err != nil {
+			log.Println("ERROR:", err)
+			status = 1
+			continue
+		}
+		logDebug("compressing the archives of", file)
+		// The failures are logged by the rotate logger already.
+		if err := rotate.CompressPending(appenderOptions(file)); err != nil {
+			status = 1
+		}
+		if lock != nil {
+			lock.Close()
+		}
+	}
+	return status
+}
+
+// lockArchives takes the lock of an instance writing to path, if there has
+// been one, so none can compress the same archives at the same time. The
+// lock is held until the returned file is closed.
+func lockArchives(path string) (*os.File, error) {
+	f, err := os.Open(path + lockSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot lock output: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("cannot compress the archives of %s while another instance uses it", path)
+		}
+		return nil, fmt.Errorf("cannot lock output: %v", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// reloadableFlags are the flags a SIGHUP applies to the running process.
+// Everything else is only read at startup.
+var reloadableFlags = map[string]bool{
+	"max-files":           true,
+	"max-size":            true,
+	"syslog-target":       true,
+	"syslog-regexp":       true,
+	"syslog-priority":     true,
+	"syslog-tag":          true,
+	"syslog-min-level":    true,
+	"kafka-regexp":        true,
+	"journald-regexp":     true,
+	"journald-priority":   true,
+	"journald-identifier": true,
+	"gelf-regexp":         true,
+	"flush-on-regexp":     true,
+	"log-level":           true,
+}
+
+// config sets flags from a file of "name = value" lines. Flags given on the
+// command line or through the environment take precedence over the file.
+type config struct {
+	fs       *flag.FlagSet
+	fileName string
+	fixed    map[string]bool
+	applied  map[string]bool
+}
+
+// newConfig has to be called after fs.Parse to know which flags are fixed.
+func newConfig(fs *flag.FlagSet, fileName string) *config {
+	c := &config{
+		fs:       fs,
+		fileName: fileName,
+		fixed:    map[string]bool{"config": true},
+		applied:  make(map[string]bool),
+	}
+	fs.Visit(func(f *flag.Flag) {
+		c.fixed[f.Name] = true
+	})
+	return c
+}
+
+// load reads the file and applies it to the flags in only, or to all flags
+// if only is nil. Flags that were removed from the file are reset to their
+// defaults. Nothing is changed if the file has an error.
+func (c *config) load(only map[string]bool) error {
+	values, err := readConfigFile(c.fileName)
+	if err != nil {
+		return err
+	}
+	for name := range values {
+		if c.fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown flag %q", c.fileName, name)
+		}
+	}
+
+	saved := make(map[string]string)
+	c.fs.VisitAll(func(f *flag.Flag) {
+		saved[f.Name] = f.Value.String()
+	})
+
+	applied := make(map[string]bool)
+	c.fs.VisitAll(func(f *flag.Flag) {
+		value, ok := values[f.Name]
+		if !ok && c.applied[f.Name] {
+			value, ok = f.DefValue, true
+		}
+		if !ok || c.fixed[f.Name] || err != nil {
+			return
+		}
+		if only != nil && !only[f.Name] {
+			if value != f.Value.String() {
+				logWarn("changing", f.Name, "requires a restart, ignoring it")
+			}
+			return
+		}
+
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("%s: invalid value %q for %s: %v", c.fileName, value, f.Name, setErr)
+		}
+		if _, inFile := values[f.Name]; inFile {
+			applied[f.Name] = true
+		}
+	})
+
+	if err != nil {
+		for name, value := range saved {
+			c.fs.Lookup(name).Value.Set(value)
+		}
+		return err
+	}
+	for name := range c.applied {
+		if only != nil && !only[name] {
+			applied[name] = true
+		}
+	}
+	c.applied = applied
+	return nil
+}
+
+// readConfigFile parses lines of "name = value" pairs. Leading dashes of the
+// name and double quotes around the value are optional, empty lines and
+// lines starting with # are ignored.
+func readConfigFile(fileName string) (map[string]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected name = value", fileName, lineNo)
+		}
+		name := strings.TrimLeft(strings.TrimSpace(parts[0]), "-")
+		value := strings.TrimSpace(parts[1])
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value, err = strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+			}
+		}
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
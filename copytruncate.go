@@ -0,0 +1,31 @@
+package main
+
+import "time"
+
+// copyTruncate rotates --output, which another program writes, by copying
+// and truncating it whenever it reached --max-size. It returns only when
+// the pipeline is closed.
+func (s *pipeline) copyTruncate() {
+	ticker := time.NewTicker(*copyInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.closed.Load() {
+			return
+		}
+		size, err := s.appender.Size()
+		if err != nil {
+			logWarn("cannot check size of output:", err)
+			continue
+		}
+
+		s.mu.Lock()
+		full := size >= int64(*maxFileSize)
+		s.mu.Unlock()
+		if full {
+			if err := s.appender.CopyTruncate(); err != nil {
+				logError("cannot rotate output:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// daemonEnv marks the process started by --daemon, which runs in the
+// background instead of starting another one.
+const daemonEnv = "_STDIN_ROTATE_DAEMONIZED"
+
+// daemonize starts this program again in a new session with stderr going
+// to --daemon-log and waits until it wrote --pidfile, i.e. is ready to read
+// lines. It returns in the background process and exits otherwise.
+func daemonize() {
+	if os.Getenv(daemonEnv) != "" {
+		os.Unsetenv(daemonEnv)
+		return
+	}
+	if *pidfile == "" {
+		log.Fatalln("ERROR: --daemon requires --pidfile")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalln("ERROR: cannot daemonize:", err)
+	}
+	stderr, err := os.OpenFile(*daemonLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatalln("ERROR: cannot open daemon log:", err)
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		log.Fatalln("ERROR: cannot daemonize:", err)
+	}
+	stderr.Close()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	for {
+		select {
+		case err := <-exited:
+			if *daemonLog != os.DevNull {
+				log.Fatalf("ERROR: daemon exited before it was ready: %v, see %s", err, *daemonLog)
+			}
+			log.Fatalln("ERROR: daemon exited before it was ready:", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if pidfilePID() == cmd.Process.Pid {
+			fmt.Println(cmd.Process.Pid)
+			os.Exit(0)
+		}
+	}
+}
+
+// pidfilePID returns the process id in --pidfile, 0 if there is none.
+func pidfilePID() int {
+	data, err := ioutil.ReadFile(*pidfile)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
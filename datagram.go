@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// listenDatagrams opens addr for --listen-udp or --listen-unixgram and asks
+// the kernel to report the datagrams it dropped because they were not read
+// fast enough.
+func listenDatagrams(network, addr string) (net.PacketConn, error) {
+	if network == "unixgram" {
+		if err := removeStaleSocket(addr); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for %s datagrams: %v", network, err)
+	}
+	if network == "unixgram" {
+		if err := chmodSocket(addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if err := enableDropCount(conn.(syscall.Conn)); err != nil {
+		logWarn("cannot count dropped", network, "datagrams:", err)
+	}
+	return conn, nil
+}
+
+// serveDatagrams appends every datagram received on conn as one line with
+// prefix, without its trailing newline. It returns only if reading fails.
+func (s *pipeline) serveDatagrams(conn net.PacketConn, network, prefix string) error {
+	buf := make([]byte, *maxDatagram)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	var dropped uint32
+	for {
+		n, oobn, flags, addr, err := readDatagram(conn, buf, oob)
+		if err != nil {
+			return fmt.Errorf("cannot receive datagram: %v", err)
+		}
+
+		s.metrics.Add(network+".datagrams", 1)
+		if flags&syscall.MSG_TRUNC != 0 {
+			s.metrics.Add(network+".truncated", 1)
+			logDebug("truncated", network, "datagram to", n, "bytes")
+		}
+		if total, ok := droppedDatagrams(oob[:oobn]); ok {
+			// The kernel reports the total since the socket was opened.
+			if total > dropped {
+				s.metrics.Add(network+".dropped", int64(total-dropped))
+			}
+			dropped = total
+		}
+
+		line := strings.TrimRight(string(buf[:n]), "\r\n")
+		if *peerPrefix && network == "udp" {
+			line = addr + " " + line
+		}
+		s.Append(prefix + line)
+	}
+}
+
+// readDatagram reads a datagram with its control messages, which
+// net.PacketConn does not provide.
+func readDatagram(conn net.PacketConn, buf, oob []byte) (n, oobn, flags int, addr string, err error) {
+	switch c := conn.(type) {
+	case *net.UDPConn:
+		var from *net.UDPAddr
+		n, oobn, flags, from, err = c.ReadMsgUDP(buf, oob)
+		if from != nil {
+			addr = from.String()
+		}
+	case *net.UnixConn:
+		var from *net.UnixAddr
+		n, oobn, flags, from, err = c.ReadMsgUnix(buf, oob)
+		if from != nil {
+			addr = from.String()
+		}
+	default:
+		var from net.Addr
+		n, from, err = conn.ReadFrom(buf)
+		if from != nil {
+			addr = from.String()
+		}
+	}
+	return
+}
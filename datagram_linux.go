@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+func enableDropCount(conn syscall.Conn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RXQ_OVFL, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// droppedDatagrams returns the SO_RXQ_OVFL counter from the control
+// messages of a received datagram.
+func droppedDatagrams(oob []byte) (uint32, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.SOL_SOCKET && msg.Header.Type == syscall.SO_RXQ_OVFL && len(msg.Data) >= 4 {
+			return binary.NativeEndian.Uint32(msg.Data), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// Only Linux reports dropped datagrams, the .dropped metrics stay 0 elsewhere.
+func enableDropCount(conn syscall.Conn) error { return nil }
+
+func droppedDatagrams(oob []byte) (uint32, bool) { return 0, false }
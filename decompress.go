@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// checkInputCompression validates --input-compression.
+func checkInputCompression() error {
+	switch *inputCompress {
+	case "none", "gzip", "zstd", "auto":
+		return nil
+	}
+	return fmt.Errorf("unknown --input-compression %q", *inputCompress)
+}
+
+// readCompressed appends the lines of r decompressed according to
+// --input-compression with prefix. auto, the default, looks at the magic
+// number of the stream and reads it as is if it is neither gzip nor zstd.
+// An error in the compressed data, e.g. because it was cut off, ends the
+// stream after the lines read up to it.
+func (s *pipeline) readCompressed(r io.Reader, name, prefix string) {
+	if *inputCompress == "none" {
+		s.readLines(r, prefix)
+		return
+	}
+
+	br := bufio.NewReader(r)
+	compression := *inputCompress
+	if compression == "auto" {
+		compression = detectCompression(br)
+		switch compression {
+		case "gzip", "zstd":
+			logDebug("reading", compression, "compressed", name)
+		default:
+			s.readLines(br, prefix)
+			return
+		}
+	}
+
+	var err error
+	if compression == "gzip" {
+		err = s.readGzip(br, prefix)
+	} else {
+		err = s.readZstd(br, prefix)
+	}
+	if err != nil {
+		logError("cannot read compressed", name+":", err)
+		s.metrics.Add("input.decompress_errors", 1)
+	}
+}
+
+// detectCompression returns the compression of br by its magic number, or
+// "none". It only waits for more than the first read returned if that
+// could be the start of a magic number, so a plain first line is not held
+// back.
+func detectCompression(br *bufio.Reader) string {
+	if _, err := br.Peek(1); err != nil {
+		return "none"
+	}
+	magic, _ := br.Peek(br.Buffered())
+	for _, m := range []struct {
+		name  string
+		magic []byte
+	}{{"gzip", gzipMagic}, {"zstd", zstdMagic}} {
+		if len(magic) < len(m.magic) && bytes.HasPrefix(m.magic, magic) {
+			magic, _ = br.Peek(len(m.magic))
+		}
+		if bytes.HasPrefix(magic, m.magic) {
+			return m.name
+		}
+	}
+	return "none"
+}
+
+// readGzip reads concatenated gzip members like gzip -d does.
+func (s *pipeline) readGzip(r io.Reader, prefix string) error {
+	gz, err := gzip.NewReader(r)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return s.readLines(gz, prefix)
+}
+
+// readZstd decompresses with the zstd binary, as the standard library has
+// no zstd decoder.
+func (s *pipeline) readZstd(r io.Reader, prefix string) error {
+	cmd := exec.Command("zstd", "-d", "-c", "-q")
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	readErr := s.readLines(stdout, prefix)
+	// Ends zstd with SIGPIPE if not everything was read, instead of
+	// leaving it blocked on the full pipe.
+	stdout.Close()
+	waitErr := cmd.Wait()
+	if readErr != nil || s.closed.Load() {
+		return readErr
+	}
+	return waitErr
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// probeTimeout is how long doctor waits for a target to answer.
+const probeTimeout = 3 * time.Second
+
+// doctor collects the findings of runDoctor.
+type doctor struct {
+	invalid, problems int
+}
+
+func (d *doctor) ok(v ...interface{})   { fmt.Println("OK:", fmt.Sprint(v...)) }
+func (d *doctor) warn(v ...interface{}) { fmt.Println("WARN:", fmt.Sprint(v...)) }
+
+// fail reports a problem of the host along with what to do about it.
+func (d *doctor) fail(problem, advice string) {
+	d.problems++
+	fmt.Printf("ERROR: %s; %s\n", problem, advice)
+}
+
+// runDoctor checks the host for running with the given flags, beyond what
+// --check does, and simulates a rotation next to --output, printing a line
+// for every finding. It exits like --check, so it can fail deployments.
+func runDoctor(args []string) int {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate doctor [FLAGS]\n\t\tchecks the host for running with FLAGS and rotates a test file next to --output\n\nFLAGS:\n")
+		flag.PrintDefaults()
+	}
+	if args := parseSubcommand(flag.CommandLine, args); len(args) != 0 {
+		flag.Usage()
+		return 2
+	}
+	if *configFile != "" {
+		if err := newConfig(flag.CommandLine, *configFile).load(nil); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if err := applyInstance(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if err := setupLogging(*logLevelName, *logFormat, *logFile); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+
+	d := &doctor{}
+	invalid, environment := checkConfig()
+	for _, problem := range invalid {
+		d.invalid++
+		fmt.Println("ERROR:", problem)
+	}
+	if len(invalid) == 0 {
+		d.ok("the flags and their regular expressions are valid")
+	}
+	for _, problem := range environment {
+		d.problems++
+		fmt.Println("ERROR:", problem)
+	}
+	d.checkDirectories()
+	if !*noFile {
+		d.checkSpace()
+	}
+	d.checkLimits()
+	d.checkTargets()
+	if !*noFile && len(invalid) == 0 {
+		d.simulateRotation()
+	}
+
+	switch {
+	case d.invalid > 0:
+		return checkInvalid
+	case d.problems > 0:
+		return checkEnvironment
+	}
+	return checkOK
+}
+
+// checkDirectories checks that the directories of the files written besides
+// --output, which --check covers, can be written to.
+func (d *doctor) checkDirectories() {
+	files := []string{*fallbackOutput, *stderrOutput, *jsonInvalid, *pidfile, *logFile, *statsFile, *rotateMarker}
+	routes, _ := parseRoutes(*routeFiles)
+	for _, fileName := range routes {
+		files = append(files, fileName)
+	}
+	checked := map[string]bool{}
+	if !*noFile {
+		checked[path.Dir(*outputFile)] = true
+	}
+	for _, fileName := range files {
+		if fileName == "" || checked[path.Dir(fileName)] {
+			continue
+		}
+		dir := path.Dir(fileName)
+		checked[dir] = true
+		if err := checkWritable(dir); err != nil {
+			d.fail(fmt.Sprintf("cannot write %s: %v", fileName, err), "create "+dir+" or give the user running stdin-rotate write access to it")
+		}
+	}
+	if !*noFile && checkWritable(path.Dir(*outputFile)) == nil {
+		if info, err := os.Stat(path.Dir(*outputFile)); err == nil {
+			d.ok(fmt.Sprintf("%s is writable, mode %s", path.Dir(*outputFile), info.Mode().Perm()))
+		}
+	}
+}
+
+// checkSpace compares the free space on the disk of --output with what the
+// output and the archives take at most before compression.
+func (d *doctor) checkSpace() {
+	free, err := rotate.FreeSpace(*outputFile)
+	if err != nil {
+		d.warn("cannot check the free space: ", err)
+		return
+	}
+	size := int64(*maxFileSize)
+	switch {
+	case free < size:
+		d.fail(fmt.Sprintf("only %s free on the disk of %s, less than --max-size %s", formatSize(free), *outputFile, formatSize(size)),
+			"free space or lower --max-size")
+	case free < int64(*minFree):
+		d.fail(fmt.Sprintf("only %s free on the disk of %s, less than --min-free %s", formatSize(free), *outputFile, formatSize(int64(*minFree))),
+			"free space or lower --min-free, archives are removed regardless of --max-files until then")
+	case retainedFiles() > 0 && free < size*int64(retainedFiles()+1):
+		d.warn(fmt.Sprintf("%s free on the disk of %s, less than the %s --max-size and --max-files can take uncompressed",
+			formatSize(free), *outputFile, formatSize(size*int64(retainedFiles()+1))))
+	default:
+		d.ok(formatSize(free), " free on the disk of ", *outputFile)
+	}
+}
+
+// checkLimits checks the resource limits writing and accepting connections
+// run into.
+func (d *doctor) checkLimits() {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err == nil && limit.Cur < math.MaxInt64 {
+		if int64(limit.Cur) < int64(*maxFileSize) {
+			d.fail(fmt.Sprintf("files are limited to %s, less than --max-size %s", formatSize(int64(limit.Cur)), formatSize(int64(*maxFileSize))),
+				"raise it with ulimit -f or LimitFSIZE= of systemd, or lower --max-size")
+		} else {
+			d.warn("files are limited to ", formatSize(int64(limit.Cur)), " by ulimit -f")
+		}
+	}
+
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		d.warn("cannot check the limit of open files: ", err)
+		return
+	}
+	needed := uint64(64)
+	if *listenTCP != "" || *listenUnix != "" {
+		needed = 1024
+	}
+	if limit.Cur < needed {
+		d.fail(fmt.Sprintf("open files are limited to %d, fewer than the %d recommended", limit.Cur, needed),
+			"raise it with ulimit -n or LimitNOFILE= of systemd")
+		return
+	}
+	d.ok("open files are limited to ", limit.Cur)
+}
+
+// checkTargets checks that the forwarding targets can be reached, beyond
+// resolving their names like --check.
+func (d *doctor) checkTargets() {
+	if *syslogTarget != "" {
+		d.probe("syslog target", "udp", *syslogTarget)
+	}
+	if *gelfTarget != "" {
+		d.probe("gelf target", *gelfProtocol, *gelfTarget)
+	}
+	if *forwardTCP != "" {
+		d.probe("forward target", "tcp", *forwardTCP)
+	}
+	if *fluentdTarget != "" {
+		d.probe("fluentd target", "tcp", *fluentdTarget)
+	}
+	if *kafkaBrokers != "" {
+		for _, broker := range strings.Split(*kafkaBrokers, ",") {
+			d.probe("kafka broker", "tcp", broker)
+		}
+	}
+	if u, err := url.Parse(*httpTarget); *httpTarget != "" && err == nil {
+		port := u.Port()
+		if port == "" && u.Scheme == "https" {
+			port = "443"
+		} else if port == "" {
+			port = "80"
+		}
+		d.probe("http target", "tcp", net.JoinHostPort(u.Hostname(), port))
+	}
+}
+
+// probe connects to address. UDP has no connection, so an empty datagram
+// is sent instead, which a closed port answers with an ICMP error that the
+// read reports. Not hearing anything is as good as it gets.
+func (d *doctor) probe(name, network, address string) {
+	if network == "udp" {
+		if _, err := net.ResolveUDPAddr("udp", address); err != nil {
+			return // Reported by checkConfig.
+		}
+	}
+	conn, err := net.DialTimeout(network, address, probeTimeout)
+	if err == nil && network == "udp" {
+		if _, err = conn.Write(nil); err == nil {
+			conn.SetReadDeadline(time.Now().Add(probeTimeout))
+			_, err = conn.Read(make([]byte, 1))
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				err = nil
+			}
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	if err != nil {
+		d.fail(fmt.Sprintf("cannot reach %s %s: %v", name, address, err), "check that it is running and no firewall is in between")
+		return
+	}
+	d.ok(name, " ", address, " is reachable over ", network)
+}
+
+// simulateRotation writes, rotates and archives a test file with the flags
+// in a temporary directory next to --output, so it runs on the same
+// filesystem, or in the system's temporary directory if that fails.
+func (d *doctor) simulateRotation() {
+	dir, err := ioutil.TempDir(path.Dir(*outputFile), ".stdin-rotate-doctor")
+	if err != nil {
+		if dir, err = ioutil.TempDir("", "stdin-rotate-doctor"); err != nil {
+			d.fail(fmt.Sprintf("cannot create a directory to rotate a test file in: %v", err), "check the permissions of "+os.TempDir())
+			return
+		}
+	}
+	defer os.RemoveAll(dir)
+
+	opts := appenderOptions(path.Join(dir, path.Base(*outputFile)))
+	opts.Metrics = rotate.NewMetrics()
+	opts.Logger = nil
+	opts.CompressWindow = rotate.Window{}
+	opts.MinFreeSpace, opts.BlockOnLowSpace = 0, false
+	opts.RotationMarker, opts.RotationFIFO = "", ""
+	opts.Shared = false
+
+	start := time.Now()
+	a, err := rotate.New(opts)
+	if err != nil {
+		d.fail(fmt.Sprintf("cannot open a test file in %s: %v", dir, err), "fix the flags or the permissions of the directory")
+		return
+	}
+	err = a.Append("stdin-rotate doctor")
+	if err == nil {
+		err = a.Rotate()
+	}
+	if closeErr := a.Close(); err == nil {
+		err = closeErr
+	}
+	for failure := range a.Errors() {
+		if err == nil {
+			err = failure
+		}
+	}
+	if err != nil {
+		d.fail(fmt.Sprintf("cannot rotate a test file in %s: %v", dir, err), "--output would fail the same way")
+		return
+	}
+
+	archives, err := rotate.Archives(opts.Path)
+	if err != nil || len(archives) != 1 {
+		d.fail(fmt.Sprintf("rotating a test file in %s left %d archives instead of one", dir, len(archives)), "please report this as a bug")
+		return
+	}
+	name := path.Base(archives[0])
+	switch {
+	case len(opts.EncryptRecipients) > 0 && !strings.HasSuffix(name, ".age") && !strings.HasSuffix(name, ".gpg"):
+		d.fail(fmt.Sprintf("the test archive %s was not encrypted", name), "check --encrypt-recipient")
+	case len(opts.EncryptRecipients) == 0 && opts.Compress && opts.KeepUncompressed == 0 && !strings.HasSuffix(name, ".gz"):
+		d.fail(fmt.Sprintf("the test archive %s was not compressed", name), "please report this as a bug")
+	default:
+		d.ok("rotated a test file to ", name, " in ", time.Since(start).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// child is the command given after "--" whose stdout is read instead of
+// stdin.
+type child struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+}
+
+// startChild runs args with our stdin and forwards the signals that stop or
+// control a process to it. SIGHUP still reloads the config.
+func startChild(args []string, stderr io.Writer) (*child, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start %s: %v", args[0], err)
+	}
+
+	c := &child{cmd: cmd, stdout: stdout}
+	go c.forwardSignals()
+	return c, nil
+}
+
+func (c *child) forwardSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	for sig := range ch {
+		c.cmd.Process.Signal(sig)
+	}
+}
+
+// wait waits for the child to exit after its stdout was read to the end and
+// returns its exit status, 128 plus the signal number if it was killed like
+// shells report it.
+func (c *child) wait() int {
+	err := c.cmd.Wait()
+	status := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		ws, _ := exitErr.Sys().(syscall.WaitStatus)
+		if ws.Signaled() {
+			status = 128 + int(ws.Signal())
+		} else {
+			status = ws.ExitStatus()
+		}
+	} else if err != nil {
+		logError("cannot wait for child:", err)
+		return exitError
+	}
+
+	level := levelInfo
+	if status != 0 {
+		level = levelWarn
+	}
+	command := strings.Join(c.cmd.Args, " ")
+	logEvent(level, "child_exited", logFields{"command": command, "status": status}, command, "exited with status", status)
+	return status
+}
+
+// childStderr returns where the stderr of the child goes: its own rotating
+// file with --stderr-output, the output with --stderr-prefix or our stderr.
+func (s *pipeline) childStderr() io.Writer {
+	if *stderrOutput != "" && *stderrPrefix != "" {
+		logFatal("--stderr-output and --stderr-prefix cannot be used together")
+	}
+
+	switch {
+	case *stderrOutput != "":
+		opts := appenderOptions(*stderrOutput)
+		opts.Metrics = s.metrics
+		a, err := rotate.New(opts)
+		if err != nil {
+			logFatal(err)
+		}
+		s.stderrFile = a
+		s.stderr = &lineWriter{append: func(line string) {
+			if err := a.Append(line); err != nil {
+				s.mu.Lock()
+				s.writeFailed(a, err)
+				s.mu.Unlock()
+			}
+		}}
+	case *stderrPrefix != "":
+		s.stderr = &lineWriter{append: func(line string) {
+			s.Append(*stderrPrefix + line)
+		}}
+	default:
+		return os.Stderr
+	}
+	return s.stderr
+}
+
+// closeStderr appends a last incomplete line of the child's stderr and
+// closes --stderr-output.
+func (s *pipeline) closeStderr() {
+	if s.stderr != nil {
+		s.stderr.flush()
+	}
+	if s.stderrFile != nil {
+		s.stderrFile.Close()
+	}
+}
+
+// lineWriter calls append for every line written to it. It never fails, so
+// the child is not blocked writing to a pipe nobody reads.
+type lineWriter struct {
+	append  func(line string)
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.append(string(w.partial[:i]))
+		w.partial = append(w.partial[:0], w.partial[i+1:]...)
+	}
+}
+
+func (w *lineWriter) flush() {
+	if len(w.partial) > 0 {
+		w.append(string(w.partial))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// fluentdQueueSize is how many lines wait while the aggregator is slow or
+// unreachable.
+const fluentdQueueSize = 10000
+
+// fluentdProtocol writes lines as a message of the forward mode of the
+// Fluentd forward protocol, [tag, [[time, record], ...], option], encoded as
+// msgpack. The records have the line as message and the host. With ack the
+// aggregator has to acknowledge each message by its chunk.
+type fluentdProtocol struct {
+	tag   string
+	host  string
+	ack   bool
+	chunk string
+}
+
+func (p *fluentdProtocol) write(w *bufio.Writer, lines []forwardLine) error {
+	var e msgpackEncoder
+	e.array(3)
+	e.string(p.tag)
+	e.array(len(lines))
+	for _, line := range lines {
+		e.array(2)
+		e.eventTime(line.time)
+		e.mapHeader(2)
+		e.string("message")
+		e.bytes(line.text)
+		e.string("host")
+		e.string(p.host)
+	}
+
+	options := 1
+	if p.ack {
+		options++
+	}
+	e.mapHeader(options)
+	e.string("size")
+	e.uint32(uint32(len(lines)))
+	if p.ack {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		p.chunk = base64.StdEncoding.EncodeToString(id)
+		e.string("chunk")
+		e.string(p.chunk)
+	}
+	_, err := w.Write(e.Bytes())
+	return err
+}
+
+// confirm reads the response {"ack": chunk} to the message written last.
+func (p *fluentdProtocol) confirm(conn net.Conn) error {
+	if !p.ack {
+		return nil
+	}
+	response, err := readMsgpackStringMap(bufio.NewReader(conn))
+	if err != nil {
+		return fmt.Errorf("cannot read ack: %v", err)
+	}
+	if response["ack"] != p.chunk {
+		return fmt.Errorf("ack for chunk %q instead of %q", response["ack"], p.chunk)
+	}
+	return nil
+}
+
+// openFluentd starts sending the lines to --fluentd-target.
+func (s *pipeline) openFluentd() {
+	if *fluentdTarget == "" {
+		return
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		logFatal(err)
+	}
+	protocol := &fluentdProtocol{tag: *fluentdTag, host: host, ack: *fluentdAck}
+	s.fluentd = newTCPForwarder("fluentd", *fluentdTarget, protocol, fluentdQueueSize, s.metrics)
+}
+
+// msgpackEncoder encodes the few msgpack types the forward protocol needs.
+type msgpackEncoder struct {
+	bytes.Buffer
+}
+
+func (e *msgpackEncoder) array(n int) {
+	e.header(n, 0x90, 16, 0xdc, 0xdd)
+}
+
+func (e *msgpackEncoder) mapHeader(n int) {
+	e.header(n, 0x80, 16, 0xde, 0xdf)
+}
+
+func (e *msgpackEncoder) string(s string) {
+	e.stringHeader(len(s))
+	e.WriteString(s)
+}
+
+// bytes encodes b as a string, as Fluentd expects the fields of records to
+// be, even if it is not valid UTF-8.
+func (e *msgpackEncoder) bytes(b []byte) {
+	e.stringHeader(len(b))
+	e.Write(b)
+}
+
+func (e *msgpackEncoder) stringHeader(n int) {
+	if n < 1<<8 && n >= 32 {
+		e.WriteByte(0xd9)
+		e.WriteByte(byte(n))
+		return
+	}
+	e.header(n, 0xa0, 32, 0xda, 0xdb)
+}
+
+// header encodes the type of an array, map or string of length n, fixed
+// below limit, with 16 or 32 bits for the length otherwise.
+func (e *msgpackEncoder) header(n int, fixed byte, limit int, type16, type32 byte) {
+	var b [4]byte
+	switch {
+	case n < limit:
+		e.WriteByte(fixed | byte(n))
+	case n < 1<<16:
+		e.WriteByte(type16)
+		binary.BigEndian.PutUint16(b[:2], uint16(n))
+		e.Write(b[:2])
+	default:
+		e.WriteByte(type32)
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		e.Write(b[:])
+	}
+}
+
+func (e *msgpackEncoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.WriteByte(0xce)
+	e.Write(b[:])
+}
+
+// eventTime encodes t as EventTime, the extension type 0 of seconds and
+// nanoseconds.
+func (e *msgpackEncoder) eventTime(t time.Time) {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	e.WriteByte(0xd7)
+	e.WriteByte(0x00)
+	e.Write(b[:])
+}
+
+// readMsgpackStringMap reads a msgpack map of strings to strings, as the
+// acks of Fluentd are.
+func readMsgpackStringMap(r *bufio.Reader) (map[string]string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	switch {
+	case b&0xf0 == 0x80:
+		n = int(b & 0x0f)
+	case b == 0xde:
+		n, err = readMsgpackLength(r, 2)
+	case b == 0xdf:
+		n, err = readMsgpackLength(r, 4)
+	default:
+		return nil, fmt.Errorf("unexpected msgpack type 0x%02x instead of a map", b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key, err := readMsgpackString(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readMsgpackString(r)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+func readMsgpackString(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case b&0xe0 == 0xa0:
+		n = int(b & 0x1f)
+	case b == 0xd9:
+		n, err = readMsgpackLength(r, 1)
+	case b == 0xda:
+		n, err = readMsgpackLength(r, 2)
+	case b == 0xdb:
+		n, err = readMsgpackLength(r, 4)
+	default:
+		return "", fmt.Errorf("unexpected msgpack type 0x%02x instead of a string", b)
+	}
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, n)
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
+
+func readMsgpackLength(r *bufio.Reader, size int) (int, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// followInterval is how often --follow checks for new lines and whether the
+// file was replaced or truncated.
+const followInterval = 250 * time.Millisecond
+
+// follower reads the lines appended to a file like tail -F. The file is
+// opened again when it is replaced, e.g. renamed by the application's own
+// rotation, and read from the start when it is truncated.
+type follower struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial string
+	emit    func(line string)
+}
+
+// follow appends the lines written to the file at path from now on with
+// prefix. It returns only when the pipeline is closed.
+func (s *pipeline) follow(path, prefix string) error {
+	f := &follower{path: path, emit: func(line string) { s.Append(prefix + line) }}
+	defer f.close()
+
+	if f.open(io.SeekEnd) != nil {
+		logInfo("waiting for", path, "to be created")
+	}
+	f.run(func() bool { return s.closed.Load() }, func(change fileChange) {
+		switch change {
+		case fileOpened:
+			logDebug("following", path)
+		case fileReplaced:
+			logInfo(path, "was replaced, following the new file")
+			s.metrics.Add("follow.reopens", 1)
+		case fileTruncated:
+			logInfo(path, "was truncated, reading it from the start")
+			s.metrics.Add("follow.truncations", 1)
+		}
+	})
+	return nil
+}
+
+// run emits the lines appended to the file until stopped reports true and
+// calls changed whenever the file was opened, replaced or truncated.
+func (f *follower) run(stopped func() bool, changed func(fileChange)) {
+	for !stopped() {
+		if f.file == nil {
+			if f.open(io.SeekStart) != nil {
+				time.Sleep(followInterval)
+				continue
+			}
+			changed(fileOpened)
+		}
+
+		f.readLines(stopped)
+		switch change := f.check(); change {
+		case fileReplaced:
+			// Lines may have been written before the new file was created.
+			f.readLines(stopped)
+			if f.partial != "" {
+				f.emit(f.partial)
+			}
+			changed(change)
+			f.close()
+			continue
+		case fileTruncated:
+			changed(change)
+			f.seek(0)
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// open opens the file and positions it at whence, io.SeekEnd to skip what
+// was written before.
+func (f *follower) open(whence int) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	offset, err := file.Seek(0, whence)
+	if err != nil {
+		offset = 0
+	}
+	f.seek(offset)
+	return nil
+}
+
+func (f *follower) seek(offset int64) {
+	f.file.Seek(offset, io.SeekStart)
+	f.offset = offset
+	f.partial = ""
+	f.reader = bufio.NewReader(f.file)
+}
+
+func (f *follower) close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
+
+// readLines emits the complete lines up to the end of the file. An
+// incomplete last line is kept until it is completed.
+func (f *follower) readLines(stopped func() bool) {
+	for !stopped() {
+		line, err := f.reader.ReadString('\n')
+		f.offset += int64(len(line))
+		if err != nil {
+			f.partial += line
+			return
+		}
+		f.emit(f.partial + strings.TrimSuffix(line, "\n"))
+		f.partial = ""
+	}
+}
+
+type fileChange int
+
+const (
+	fileUnchanged fileChange = iota
+	fileOpened
+	fileReplaced
+	fileTruncated
+)
+
+// check reports whether the path leads to another file than the open one
+// or the open one is shorter than what was read.
+func (f *follower) check() fileChange {
+	open, err := f.file.Stat()
+	if err != nil {
+		return fileUnchanged
+	}
+	current, err := os.Stat(f.path)
+	if err != nil || !os.SameFile(open, current) {
+		return fileReplaced
+	}
+	if current.Size() < f.offset {
+		return fileTruncated
+	}
+	return fileUnchanged
+}
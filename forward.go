@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+const (
+	// forwardBatchSize is how many lines are written at most before the
+	// connection is flushed.
+	forwardBatchSize = 500
+	forwardTimeout   = 10 * time.Second
+	forwardMinDelay  = 100 * time.Millisecond
+	forwardMaxDelay  = 10 * time.Second
+)
+
+// tcpForwarder mirrors lines to a TCP endpoint from its own goroutine,
+// encoded by its protocol. It reconnects whenever the connection breaks and
+// sends the lines that were not acknowledged by a flush, or by the endpoint
+// if the protocol does, again, so while it is disconnected up to the size of
+// its buffer lines wait. Its metrics and events are named after name.
+type tcpForwarder struct {
+	name     string
+	addr     string
+	protocol forwardProtocol
+	metrics  *rotate.Metrics
+
+	lines     chan forwardLine
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   int64
+
+	conn    net.Conn
+	writer  *bufio.Writer
+	pending []forwardLine
+	// stopping is set once Close was called, gaveUp once sending failed
+	// after that, so the remaining lines are not retried.
+	stopping bool
+	gaveUp   bool
+}
+
+// forwardLine is a queued line with when it was read.
+type forwardLine struct {
+	text []byte
+	time time.Time
+}
+
+// forwardProtocol encodes the lines sent by a tcpForwarder.
+type forwardProtocol interface {
+	// write encodes lines to w.
+	write(w *bufio.Writer, lines []forwardLine) error
+	// confirm waits until the endpoint acknowledged what was written, if
+	// the protocol has acknowledgements.
+	confirm(conn net.Conn) error
+}
+
+// newlineProtocol writes each line followed by a newline.
+type newlineProtocol struct{}
+
+func (newlineProtocol) write(w *bufio.Writer, lines []forwardLine) error {
+	for _, line := range lines {
+		w.Write(line.text)
+		w.WriteByte('\n')
+	}
+	return nil
+}
+
+func (newlineProtocol) confirm(net.Conn) error {
+	return nil
+}
+
+func newTCPForwarder(name, addr string, protocol forwardProtocol, bufferSize int, metrics *rotate.Metrics) *tcpForwarder {
+	f := &tcpForwarder{
+		name:     name,
+		addr:     addr,
+		protocol: protocol,
+		metrics:  metrics,
+		lines:    make(chan forwardLine, bufferSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// Write queues a copy of line. It never blocks; lines are dropped if the
+// buffer is full because the endpoint is slow or unreachable.
+func (f *tcpForwarder) Write(line []byte) (int, error) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case f.lines <- forwardLine{buf, time.Now()}:
+	default:
+		atomic.AddInt64(&f.dropped, 1)
+		f.metrics.Add(f.name+".dropped", 1)
+	}
+	return len(line), nil
+}
+
+// Close sends the remaining queued lines, trying to reconnect once if
+// needed, and closes the connection. The lines it cannot send are counted
+// as lost. Calling it again waits for the first call to finish.
+func (f *tcpForwarder) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.lines)
+		close(f.stop)
+		<-f.done
+	})
+	return nil
+}
+
+func (f *tcpForwarder) run() {
+	defer close(f.done)
+	for line := range f.lines {
+		f.pending = append(f.pending, line)
+		if len(f.lines) > 0 && len(f.pending) < forwardBatchSize {
+			continue
+		}
+		f.flush()
+	}
+
+	f.stopping = true
+	f.flush()
+	if f.conn != nil {
+		f.conn.Close()
+	}
+}
+
+// flush sends the pending lines, reconnecting with growing delays until it
+// succeeds. Once stopping it tries only once and gives up on the lines left.
+func (f *tcpForwarder) flush() {
+	delay := forwardMinDelay
+	for len(f.pending) > 0 {
+		if f.gaveUp {
+			f.metrics.Add(f.name+".lost", int64(len(f.pending)))
+			f.pending = f.pending[:0]
+			break
+		}
+		err := f.send()
+		if err == nil {
+			f.metrics.Add(f.name+".lines", int64(len(f.pending)))
+			f.pending = f.pending[:0]
+			break
+		}
+		f.metrics.Add(f.name+".errors", 1)
+		if f.stopping {
+			logEvent(levelError, f.name+"_failed", logFields{"target": f.addr, "error": err.Error()},
+				"cannot forward the remaining lines to", f.addr+":", err)
+			f.gaveUp = true
+			continue
+		}
+		logEvent(levelWarn, f.name+"_failed", logFields{"target": f.addr, "error": err.Error()}, "cannot forward lines to", f.addr+", retrying in", delay.String()+":", err)
+		select {
+		case <-time.After(delay):
+		case <-f.stop:
+			f.stopping = true
+		}
+		if delay *= 2; delay > forwardMaxDelay {
+			delay = forwardMaxDelay
+		}
+	}
+	if dropped := atomic.SwapInt64(&f.dropped, 0); dropped > 0 {
+		logEvent(levelWarn, f.name+"_dropped", logFields{"target": f.addr, "lines": dropped}, f.name, "buffer full, dropped", dropped, "lines")
+	}
+}
+
+// send writes the pending lines, connecting first if needed. The connection
+// is dropped if it fails.
+func (f *tcpForwarder) send() error {
+	if f.conn == nil {
+		conn, err := net.DialTimeout("tcp", f.addr, forwardTimeout)
+		if err != nil {
+			return err
+		}
+		f.conn = conn
+		f.writer = bufio.NewWriter(conn)
+		f.metrics.Add(f.name+".connects", 1)
+	}
+
+	f.conn.SetDeadline(time.Now().Add(forwardTimeout))
+	err := f.protocol.write(f.writer, f.pending)
+	if err == nil {
+		err = f.writer.Flush()
+	}
+	if err == nil {
+		err = f.protocol.confirm(f.conn)
+	}
+	if err != nil {
+		f.conn.Close()
+		f.conn = nil
+		return err
+	}
+	return nil
+}
+
+// openForward starts mirroring the lines to --forward-tcp.
+func (s *pipeline) openForward() {
+	if *forwardTCP == "" {
+		return
+	}
+	if *forwardBuffer <= 0 {
+		logFatal("--forward-tcp-buffer must be positive")
+	}
+	s.forward = newTCPForwarder("forward", *forwardTCP, newlineProtocol{}, *forwardBuffer, s.metrics)
+}
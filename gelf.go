@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+const (
+	gelfQueueSize = 10000
+	// gelfChunkSize is the payload of a UDP chunk, small enough for the
+	// MTU of most networks. Graylog accepts at most gelfMaxChunks of them.
+	gelfChunkSize = 1420
+	gelfMaxChunks = 128
+	gelfTimeout   = 10 * time.Second
+)
+
+// gelfSender sends lines as GELF messages to Graylog from its own
+// goroutine, over UDP in chunks if needed or over TCP framed by null bytes.
+type gelfSender struct {
+	network string
+	addr    string
+	host    string
+	metrics *rotate.Metrics
+
+	lines     chan gelfLine
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	dropped   int64
+	conn      net.Conn
+}
+
+// gelfLine is a queued line with its syslog severity and when it was read.
+type gelfLine struct {
+	text  []byte
+	level int
+	time  time.Time
+}
+
+// gelfMessage is the payload of GELF 1.1.
+type gelfMessage struct {
+	Version      string  `json:"version"`
+	Host         string  `json:"host"`
+	ShortMessage string  `json:"short_message"`
+	Timestamp    float64 `json:"timestamp"`
+	Level        int     `json:"level"`
+	Tag          string  `json:"_tag,omitempty"`
+}
+
+func newGelfSender(network, addr string, metrics *rotate.Metrics) (*gelfSender, error) {
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("unknown --gelf-protocol %q", network)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	g := &gelfSender{
+		network: network,
+		addr:    addr,
+		host:    host,
+		metrics: metrics,
+		lines:   make(chan gelfLine, gelfQueueSize),
+		done:    make(chan struct{}),
+	}
+	if err := g.connect(); err != nil {
+		return nil, err
+	}
+	go g.run()
+	return g, nil
+}
+
+// Write queues a copy of line with level. It never blocks; lines are
+// dropped if the queue is full because Graylog cannot keep up.
+func (g *gelfSender) Write(line []byte, level int) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case g.lines <- gelfLine{buf, level, time.Now()}:
+	default:
+		atomic.AddInt64(&g.dropped, 1)
+		g.metrics.Add("gelf.dropped", 1)
+	}
+}
+
+// Close sends the remaining queued lines and closes the connection.
+// Calling it again waits for the first call to finish.
+func (g *gelfSender) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.lines)
+		<-g.done
+		if g.conn != nil {
+			g.closeErr = g.conn.Close()
+		}
+	})
+	return g.closeErr
+}
+
+func (g *gelfSender) connect() error {
+	conn, err := net.DialTimeout(g.network, g.addr, gelfTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot connect to graylog: %v", err)
+	}
+	g.conn = conn
+	return nil
+}
+
+func (g *gelfSender) run() {
+	defer close(g.done)
+	for line := range g.lines {
+		if err := g.send(line); err != nil {
+			logEvent(levelDebug, "gelf_failed", logFields{"error": err.Error()}, "cannot send line to graylog:", err)
+			g.metrics.Add("gelf.errors", 1)
+		}
+		if dropped := atomic.SwapInt64(&g.dropped, 0); dropped > 0 {
+			logEvent(levelWarn, "gelf_dropped", logFields{"lines": dropped}, "gelf queue full, dropped", dropped, "lines")
+		}
+	}
+}
+
+func (g *gelfSender) send(line gelfLine) error {
+	payload, err := json.Marshal(gelfMessage{
+		Version:      "1.1",
+		Host:         g.host,
+		ShortMessage: string(line.text),
+		Timestamp:    float64(line.time.UnixNano()/int64(time.Millisecond)) / 1000,
+		Level:        line.level,
+		Tag:          *syslogTag,
+	})
+	if err != nil {
+		return err
+	}
+
+	if g.network == "udp" {
+		return g.sendChunked(payload)
+	}
+	// Reconnect once if the connection broke since the last line.
+	if g.conn == nil {
+		if err := g.connect(); err != nil {
+			return err
+		}
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(gelfTimeout))
+	if _, err := g.conn.Write(append(payload, 0)); err != nil {
+		g.conn.Close()
+		g.conn = nil
+		return err
+	}
+	g.metrics.Add("gelf.lines", 1)
+	return nil
+}
+
+// sendChunked sends payload in one datagram, or split into GELF chunks if it
+// does not fit.
+func (g *gelfSender) sendChunked(payload []byte) error {
+	if len(payload) <= gelfChunkSize {
+		_, err := g.conn.Write(payload)
+		if err == nil {
+			g.metrics.Add("gelf.lines", 1)
+		}
+		return err
+	}
+
+	count := (len(payload) + gelfChunkSize - 1) / gelfChunkSize
+	if count > gelfMaxChunks {
+		g.metrics.Add("gelf.too_large", 1)
+		return fmt.Errorf("message of %d bytes needs more than %d chunks", len(payload), gelfMaxChunks)
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	chunk := make([]byte, 0, 12+gelfChunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * gelfChunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk = append(chunk[:0], 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*gelfChunkSize:end]...)
+		if _, err := g.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	g.metrics.Add("gelf.lines", 1)
+	g.metrics.Add("gelf.chunked", 1)
+	return nil
+}
+
+// openGelf connects to --gelf-target.
+func (s *pipeline) openGelf() {
+	if *gelfTarget == "" {
+		return
+	}
+
+	var err error
+	s.gelf, err = newGelfSender(*gelfProtocol, *gelfTarget, s.metrics)
+	if err != nil {
+		logFatal(err)
+	}
+	if err := s.compileGelfRegexp(); err != nil {
+		logFatal(err)
+	}
+}
+
+func (s *pipeline) compileGelfRegexp() error {
+	if *gelfRegexp == "" {
+		s.gelfRegexp = nil
+		return nil
+	}
+
+	re, err := regexp.Compile(*gelfRegexp)
+	if err != nil {
+		return fmt.Errorf("cannot compile gelf regexp: %v", err)
+	}
+	s.gelfRegexp = re
+	return nil
+}
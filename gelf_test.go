@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// datagramConn records the datagrams written to it.
+type datagramConn struct {
+	net.Conn
+	datagrams [][]byte
+}
+
+func (c *datagramConn) Write(b []byte) (int, error) {
+	c.datagrams = append(c.datagrams, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func newTestGelfSender() (*gelfSender, *datagramConn) {
+	conn := &datagramConn{}
+	return &gelfSender{network: "udp", conn: conn, metrics: rotate.NewMetrics()}, conn
+}
+
+func TestGelfChunking(t *testing.T) {
+	for _, test := range []struct {
+		size   int
+		chunks int
+	}{
+		{1, 0},
+		{gelfChunkSize, 0},
+		{gelfChunkSize + 1, 2},
+		{3 * gelfChunkSize, 3},
+		{gelfMaxChunks * gelfChunkSize, gelfMaxChunks},
+	} {
+		g, conn := newTestGelfSender()
+		payload := bytes.Repeat([]byte("x"), test.size)
+		if err := g.sendChunked(payload); err != nil {
+			t.Errorf("sendChunked of %d bytes: %v", test.size, err)
+			continue
+		}
+
+		if test.chunks == 0 {
+			if len(conn.datagrams) != 1 || !bytes.Equal(conn.datagrams[0], payload) {
+				t.Errorf("%d bytes sent as %d datagrams, want them in one", test.size, len(conn.datagrams))
+			}
+			continue
+		}
+		if len(conn.datagrams) != test.chunks {
+			t.Errorf("%d bytes sent in %d chunks, want %d", test.size, len(conn.datagrams), test.chunks)
+			continue
+		}
+		var joined []byte
+		id := conn.datagrams[0][2:10]
+		for i, chunk := range conn.datagrams {
+			if len(chunk) > 12+gelfChunkSize {
+				t.Errorf("chunk %d of %d bytes is %d long, over the limit of %d", i, test.size, len(chunk), 12+gelfChunkSize)
+			}
+			if !bytes.Equal(chunk[:2], []byte{0x1e, 0x0f}) {
+				t.Errorf("chunk %d of %d bytes starts with % x, not the GELF magic bytes", i, test.size, chunk[:2])
+			}
+			if !bytes.Equal(chunk[2:10], id) {
+				t.Errorf("chunk %d of %d bytes has message id % x, want % x", i, test.size, chunk[2:10], id)
+			}
+			if int(chunk[10]) != i || int(chunk[11]) != test.chunks {
+				t.Errorf("chunk %d of %d bytes numbered %d of %d, want %d of %d", i, test.size, chunk[10], chunk[11], i, test.chunks)
+			}
+			joined = append(joined, chunk[12:]...)
+		}
+		if !bytes.Equal(joined, payload) {
+			t.Errorf("chunks of %d bytes join to %d bytes, want the payload", test.size, len(joined))
+		}
+	}
+}
+
+func TestGelfTooManyChunks(t *testing.T) {
+	g, conn := newTestGelfSender()
+	if err := g.sendChunked(bytes.Repeat([]byte("x"), gelfMaxChunks*gelfChunkSize+1)); err == nil {
+		t.Error("sendChunked of a message needing too many chunks succeeded")
+	}
+	if len(conn.datagrams) != 0 {
+		t.Errorf("sent %d chunks of a message needing too many, want none", len(conn.datagrams))
+	}
+	if counters, _ := g.metrics.Snapshot(); counters["gelf.too_large"] != 1 {
+		t.Errorf("gelf.too_large = %d, want 1", counters["gelf.too_large"])
+	}
+}
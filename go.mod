@@ -0,0 +1,3 @@
+module github.com/innogames/stdin-rotate
+
+go 1.22
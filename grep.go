@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// outputFiles returns the archives of output oldest first followed by output
+// itself, in the order their lines were written.
+func outputFiles(output string) ([]string, error) {
+	// The archives of a followed link are next to its target.
+	if target, err := rotate.SymlinkTarget(output); err == nil {
+		output = target
+	}
+	files, err := rotate.Archives(output)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list archives: %v", err)
+	}
+	if _, err := os.Stat(output); err == nil {
+		files = append(files, output)
+	}
+	return files, nil
+}
+
+// parseSubcommand parses the flags of a subcommand given before or after its
+// arguments, as in "grep PATTERN -output FILE", and returns the arguments.
+func parseSubcommand(fs *flag.FlagSet, args []string) []string {
+	if err := applyEnvironment(fs); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// runGrep prints the lines of the output and its archives matching a
+// regular expression, oldest first. It exits like grep: 0 if a line matched,
+// 1 if none did and 2 on errors.
+func runGrep(args []string) int {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	output := fs.String("output", "./output.log", "Output file to search along with its archives")
+	ignoreCase := fs.Bool("i", false, "Ignore case")
+	invert := fs.Bool("v", false, "Print the lines not matching")
+	noFilename := fs.Bool("no-filename", false, "Do not prefix the lines with the file they were found in")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate grep PATTERN [FLAGS]\n\t\tsearches --output and its archives, decompressing them\n\nFLAGS:\n")
+		fs.PrintDefaults()
+	}
+	args = parseSubcommand(fs, args)
+	if len(args) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	expr := args[0]
+	if *ignoreCase {
+		expr = "(?i)" + expr
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		log.Println("ERROR: invalid pattern:", err)
+		return 2
+	}
+	files, err := outputFiles(*output)
+	if err != nil {
+		log.Println("ERROR:", err)
+		return 2
+	}
+
+	status := 1
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+	for _, fileName := range files {
+		f, err := rotate.OpenArchive(fileName)
+		if err != nil {
+			log.Println("ERROR:", err)
+			status = 2
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if pattern.MatchString(scanner.Text()) == *invert {
+				continue
+			}
+			if status == 1 {
+				status = 0
+			}
+			if !*noFilename {
+				fmt.Fprint(stdout, fileName, ":")
+			}
+			fmt.Fprintln(stdout, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			log.Println("ERROR: cannot read", fileName+":", err)
+			status = 2
+		}
+		f.Close()
+	}
+	return status
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+// TestGrepReadsArchivesInOrder checks grep finds the lines of gzipped and
+// zstd archives and of the live file, oldest first.
+func TestGrepReadsArchivesInOrder(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed:", err)
+	}
+	dir := t.TempDir()
+	output := path.Join(dir, "app.log")
+
+	f, err := os.Create(output + "_2020-01-01T00.00.00.000000000Z.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("match 1\nskip\n"))
+	gz.Close()
+	f.Close()
+
+	zstName := output + "_2020-01-02T00.00.00.000000000Z"
+	if err := ioutil.WriteFile(zstName, []byte("match 2\nskip\nmatch 3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("zstd", "-q", "--rm", zstName).CombinedOutput(); err != nil {
+		t.Fatalf("zstd: %v: %s", err, out)
+	}
+
+	if err := ioutil.WriteFile(output, []byte("skip\nmatch 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, err := os.Create(path.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	realStdout := os.Stdout
+	os.Stdout = stdout
+	status := runGrep([]string{"^match", "-output", output, "-no-filename"})
+	os.Stdout = realStdout
+
+	if status != 0 {
+		t.Errorf("grep exited with %d, want 0", status)
+	}
+	got, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "match 1\nmatch 2\nmatch 3\nmatch 4\n"; string(got) != want {
+		t.Errorf("grep printed %q, want %q", got, want)
+	}
+}
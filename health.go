@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health tracks the outcome of the latest writes to the output file and the
+// syslog server for the /healthz endpoint.
+type health struct {
+	mu        sync.Mutex
+	lastWrite time.Time
+	writeErr  error
+	syslogErr error
+
+	// pending lines are waiting to be written. Since waitingSince lines
+	// were received but none was written, it is zero if none failed or
+	// waits.
+	pending      int
+	waitingSince time.Time
+	stalled      bool
+}
+
+type healthStatus struct {
+	Healthy        bool    `json:"healthy"`
+	Output         string  `json:"output,omitempty"`
+	OutputWritable bool    `json:"output_writable"`
+	OutputError    string  `json:"output_error,omitempty"`
+	LastWriteAge   float64 `json:"last_write_age_seconds"`
+	Stalled        bool    `json:"stalled,omitempty"`
+	QueueDepth     *int    `json:"queue_depth,omitempty"`
+	Syslog         *bool   `json:"syslog_connected,omitempty"`
+	SyslogError    string  `json:"syslog_error,omitempty"`
+}
+
+// received notes a line that is about to be written.
+func (h *health) received() {
+	h.mu.Lock()
+	h.pending++
+	if h.waitingSince.IsZero() {
+		h.waitingSince = time.Now()
+	}
+	h.mu.Unlock()
+}
+
+func (h *health) wrote(err error) {
+	h.mu.Lock()
+	if h.pending > 0 {
+		h.pending--
+	}
+	if err == nil {
+		h.lastWrite = time.Now()
+		h.waitingSince = time.Time{}
+		if h.pending > 0 {
+			h.waitingSince = h.lastWrite
+		}
+	}
+	h.writeErr = err
+	h.mu.Unlock()
+}
+
+// waiting returns for how long lines were received but none was written.
+func (h *health) waiting() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.waitingSince.IsZero() {
+		return 0
+	}
+	return time.Since(h.waitingSince)
+}
+
+func (h *health) sentSyslog(err error) {
+	h.mu.Lock()
+	h.syslogErr = err
+	h.mu.Unlock()
+}
+
+func (s *pipeline) startHealth() {
+	if *healthListen == "" {
+		return
+	}
+
+	s.health.wrote(nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.serveHealth)
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	go func() {
+		logFatal("health endpoint:", http.ListenAndServe(*healthListen, mux))
+	}()
+}
+
+// serveHealth responds with 200 if the last write to the output succeeded,
+// the syslog server accepted the last line and, with --health-max-age, the
+// last write is recent enough. Otherwise it responds with 503.
+func (s *pipeline) serveHealth(w http.ResponseWriter, r *http.Request) {
+	var output string
+	if s.appender != nil {
+		output = s.appender.Path()
+	}
+
+	s.health.mu.Lock()
+	status := healthStatus{
+		Output:         output,
+		OutputWritable: s.health.writeErr == nil,
+		LastWriteAge:   time.Since(s.health.lastWrite).Seconds(),
+		Stalled:        s.health.stalled,
+	}
+	if s.queue != nil {
+		depth := s.queueDepth()
+		status.QueueDepth = &depth
+	}
+	if s.health.writeErr != nil {
+		status.OutputError = s.health.writeErr.Error()
+	}
+	if *syslogTarget != "" {
+		connected := s.health.syslogErr == nil
+		status.Syslog = &connected
+		if !connected {
+			status.SyslogError = s.health.syslogErr.Error()
+		}
+	}
+	s.health.mu.Unlock()
+
+	status.Healthy = status.OutputWritable && !status.Stalled && (status.Syslog == nil || *status.Syslog)
+	if *healthMaxAge > 0 && status.LastWriteAge > healthMaxAge.Seconds() {
+		status.Healthy = false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// metricsStatus are the cumulative counters and timings served on /metrics.
+type metricsStatus struct {
+	Counters map[string]int64        `json:"counters"`
+	Timings  map[string]timingStatus `json:"timings"`
+}
+
+type timingStatus struct {
+	Count        int64   `json:"count"`
+	TotalSeconds float64 `json:"total_seconds"`
+}
+
+// serveMetrics responds with all counters and timings since startup, among
+// them what every filter passed and every queue dropped.
+func (s *pipeline) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	counters, timings := s.metrics.Snapshot()
+	status := metricsStatus{Counters: counters, Timings: map[string]timingStatus{}}
+	for name, t := range timings {
+		status.Timings[name] = timingStatus{Count: t.Count, TotalSeconds: t.Total.Seconds()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// startWatchdog checks against --stall-timeout that the lines received make
+// it to the output, whose writes may hang e.g. on a blocked NFS mount or
+// keep failing.
+func (s *pipeline) startWatchdog() {
+	if *stallTimeout <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(*stallTimeout / 4) {
+			waiting := s.health.waiting()
+			s.health.mu.Lock()
+			stalled := waiting >= *stallTimeout
+			changed := stalled != s.health.stalled
+			s.health.stalled = stalled
+			s.health.mu.Unlock()
+
+			if !changed {
+				continue
+			}
+			if !stalled {
+				logEvent(levelInfo, "output_resumed", logFields{"file": *outputFile}, "writing to", *outputFile, "again")
+				continue
+			}
+			s.metrics.Add("stalls", 1)
+			logEvent(levelError, "output_stalled", logFields{"file": *outputFile, "waiting_seconds": waiting.Seconds()},
+				"no line could be written to", *outputFile, "for", waiting.Round(time.Second), "while input arrived")
+			if *stallExit {
+				// Closing the output would hang on the stalled write.
+				exit(exitStalled)
+			}
+		}
+	}()
+}
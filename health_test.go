@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// TestServeHealthDuringHangingWrite checks /healthz answers while a write
+// to the output file hangs with the Appender's lock held.
+func TestServeHealthDuringHangingWrite(t *testing.T) {
+	// A pipe nobody reads from blocks writes once its buffer is full.
+	fileName := path.Join(t.TempDir(), "app.log")
+	if err := syscall.Mkfifo(fileName, 0644); err != nil {
+		t.Skip("cannot create FIFO:", err)
+	}
+	reader, err := syscall.Open(fileName, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(reader)
+	a, err := rotate.New(rotate.Options{Path: fileName, MaxSize: 1 << 30, MaxFiles: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pipeline{appender: a}
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		a.Write([]byte(strings.Repeat("x", 1<<20) + "\n"))
+	}()
+	select {
+	case <-written:
+		t.Fatal("write to the FIFO did not hang")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	served := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		p.serveHealth(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		served <- w
+	}()
+	select {
+	case w := <-served:
+		if !strings.Contains(w.Body.String(), fileName) {
+			t.Errorf("response %q does not name the output %s", w.Body.String(), fileName)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("/healthz did not answer while the write hangs")
+	}
+
+	// Unblock the write by draining the FIFO.
+	go func() {
+		buf := make([]byte, 1<<16)
+		for {
+			if _, err := syscall.Read(reader, buf); err == syscall.EAGAIN {
+				time.Sleep(time.Millisecond)
+			} else if err != nil {
+				return
+			}
+			select {
+			case <-written:
+				return
+			default:
+			}
+		}
+	}()
+	<-written
+	a.Close()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// startHeartbeat appends --heartbeat-line to the output every --heartbeat,
+// so monitors checking the output for fresh lines can tell a silent
+// producer from a broken pipeline. The line only goes to the output, not to
+// syslog, Kafka or journald.
+func (s *pipeline) startHeartbeat() {
+	if *heartbeat <= 0 {
+		return
+	}
+
+	go func() {
+		for now := range time.Tick(*heartbeat) {
+			line := strings.Replace(*heartbeatLine, "{time}", now.UTC().Format(time.RFC3339), -1)
+			s.mu.Lock()
+			if !s.closed.Load() {
+				if err := s.appender.AppendBytes(s.numberLine(s.prefixInstance([]byte(line)))); err != nil {
+					s.writeFailed(s.appender, err)
+				} else {
+					s.metrics.Add("heartbeats", 1)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
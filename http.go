@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+const (
+	httpQueueSize = 10000
+	httpTimeout   = 10 * time.Second
+	httpMinDelay  = 100 * time.Millisecond
+	httpMaxDelay  = 10 * time.Second
+)
+
+// httpShipper batches lines and posts them to the push API of Grafana Loki
+// or the bulk API of Elasticsearch from its own goroutine. A batch that
+// fails because the server is unreachable or overloaded is posted again
+// with growing delays, one it rejects is dropped.
+type httpShipper struct {
+	target   string
+	format   string
+	labels   map[string]string
+	index    string
+	host     string
+	size     int
+	interval time.Duration
+	client   *http.Client
+	metrics  *rotate.Metrics
+
+	lines     chan httpLine
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   int64
+
+	batch    []httpLine
+	stopping bool
+}
+
+// httpLine is a queued line with when it was read.
+type httpLine struct {
+	text []byte
+	time time.Time
+}
+
+// lokiPush is the JSON body of the Loki push API with a single stream.
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// elasticDocument is the document a line becomes in Elasticsearch.
+type elasticDocument struct {
+	Timestamp string `json:"@timestamp"`
+	Host      string `json:"host"`
+	Message   string `json:"message"`
+}
+
+// parseLabels parses --http-labels into the labels of the Loki stream.
+func parseLabels(value string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, elem := range strings.Split(value, ",") {
+		if elem == "" {
+			continue
+		}
+		i := strings.Index(elem, "=")
+		if i <= 0 || i == len(elem)-1 {
+			return nil, fmt.Errorf("--http-labels %q is not name=value", elem)
+		}
+		labels[elem[:i]] = elem[i+1:]
+	}
+	if *httpFormat == "loki" && len(labels) == 0 {
+		return nil, fmt.Errorf("--http-labels needs at least one label for loki")
+	}
+	return labels, nil
+}
+
+// checkHTTPFlags validates the flags of the HTTP sink.
+func checkHTTPFlags() error {
+	if *httpFormat != "loki" && *httpFormat != "elasticsearch" {
+		return fmt.Errorf("unknown --http-format %q", *httpFormat)
+	}
+	if *httpBatchSize <= 0 {
+		return fmt.Errorf("--http-batch-size must be positive")
+	}
+	if *httpInterval <= 0 {
+		return fmt.Errorf("--http-batch-interval must be positive")
+	}
+	if *httpTarget == "" {
+		return nil
+	}
+	if u, err := url.Parse(*httpTarget); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("--http-target %q is not an http or https URL", *httpTarget)
+	}
+	_, err := parseLabels(*httpLabels)
+	return err
+}
+
+func newHTTPShipper(metrics *rotate.Metrics) (*httpShipper, error) {
+	if err := checkHTTPFlags(); err != nil {
+		return nil, err
+	}
+	labels, _ := parseLabels(*httpLabels)
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	h := &httpShipper{
+		target:   *httpTarget,
+		format:   *httpFormat,
+		labels:   labels,
+		index:    *httpIndex,
+		host:     host,
+		size:     *httpBatchSize,
+		interval: *httpInterval,
+		client:   &http.Client{Timeout: httpTimeout},
+		metrics:  metrics,
+		lines:    make(chan httpLine, httpQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go h.run()
+	return h, nil
+}
+
+// Write queues a copy of line. It never blocks; lines are dropped if the
+// queue is full because the server cannot keep up or is unreachable.
+func (h *httpShipper) Write(line []byte) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case h.lines <- httpLine{buf, time.Now()}:
+	default:
+		atomic.AddInt64(&h.dropped, 1)
+		h.metrics.Add("http.dropped", 1)
+	}
+}
+
+// Close posts the remaining queued lines, trying once more if that fails.
+// The lines it cannot post are counted as http.lost. Calling it again waits
+// for the first call to finish.
+func (h *httpShipper) Close() error {
+	h.closeOnce.Do(func() {
+		close(h.lines)
+		close(h.stop)
+		<-h.done
+	})
+	return nil
+}
+
+func (h *httpShipper) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-h.lines:
+			if !ok {
+				h.stopping = true
+				h.flush()
+				return
+			}
+			h.batch = append(h.batch, line)
+			if len(h.batch) >= h.size {
+				h.flush()
+			}
+		case <-ticker.C:
+			h.flush()
+		}
+	}
+}
+
+// flush posts the batch, retrying with growing delays while the server is
+// unreachable or answers with a status worth retrying.
+func (h *httpShipper) flush() {
+	delay := httpMinDelay
+	for len(h.batch) > 0 {
+		retry, err := h.post()
+		if err == nil {
+			h.metrics.Add("http.lines", int64(len(h.batch)))
+			break
+		}
+		h.metrics.Add("http.errors", 1)
+		if !retry {
+			logEvent(levelError, "http_rejected", logFields{"target": h.target, "lines": len(h.batch), "error": err.Error()},
+				"dropping", len(h.batch), "lines rejected by", h.target+":", err)
+			h.metrics.Add("http.rejected", int64(len(h.batch)))
+			break
+		}
+		if h.stopping {
+			logEvent(levelError, "http_failed", logFields{"target": h.target, "lines": len(h.batch), "error": err.Error()},
+				"cannot post the remaining", len(h.batch), "lines to", h.target+":", err)
+			h.metrics.Add("http.lost", int64(len(h.batch)))
+			break
+		}
+		logEvent(levelWarn, "http_failed", logFields{"target": h.target, "error": err.Error()}, "cannot post lines to", h.target+", retrying in", delay.String()+":", err)
+		select {
+		case <-time.After(delay):
+		case <-h.stop:
+			h.stopping = true
+		}
+		if delay *= 2; delay > httpMaxDelay {
+			delay = httpMaxDelay
+		}
+	}
+	h.batch = h.batch[:0]
+	if dropped := atomic.SwapInt64(&h.dropped, 0); dropped > 0 {
+		logEvent(levelWarn, "http_dropped", logFields{"target": h.target, "lines": dropped}, "http queue full, dropped", dropped, "lines")
+	}
+}
+
+// post sends the batch once. It reports whether posting it again may
+// succeed if it fails.
+func (h *httpShipper) post() (bool, error) {
+	var body []byte
+	var contentType string
+	var err error
+	if h.format == "loki" {
+		body, err = h.lokiBody()
+		contentType = "application/json"
+	} else {
+		body, err = h.elasticBody()
+		contentType = "application/x-ndjson"
+	}
+	if err != nil {
+		return false, err
+	}
+
+	h.metrics.Add("http.requests", 1)
+	resp, err := h.client.Post(h.target, contentType, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(content))
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(content))
+	}
+
+	if h.format == "elasticsearch" {
+		// The bulk API answers 200 even if single documents failed.
+		var result struct {
+			Errors bool `json:"errors"`
+		}
+		if json.Unmarshal(content, &result) == nil && result.Errors {
+			logEvent(levelWarn, "http_partial", logFields{"target": h.target}, "elasticsearch rejected some of the lines of a bulk request")
+			h.metrics.Add("http.partial", 1)
+		}
+	}
+	return false, nil
+}
+
+func (h *httpShipper) lokiBody() ([]byte, error) {
+	stream := lokiStream{Stream: h.labels, Values: make([][2]string, len(h.batch))}
+	for i, line := range h.batch {
+		stream.Values[i] = [2]string{strconv.FormatInt(line.time.UnixNano(), 10), string(line.text)}
+	}
+	return json.Marshal(lokiPush{Streams: []lokiStream{stream}})
+}
+
+// elasticBody returns the batch as bulk index actions. {date} in the index
+// is replaced by the UTC day the line was read on, for daily indices.
+func (h *httpShipper) elasticBody() ([]byte, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	for _, line := range h.batch {
+		index := strings.Replace(h.index, "{date}", line.time.UTC().Format("2006.01.02"), -1)
+		action := map[string]map[string]string{"index": {"_index": index}}
+		if err := encoder.Encode(action); err != nil {
+			return nil, err
+		}
+		doc := elasticDocument{
+			Timestamp: line.time.UTC().Format(time.RFC3339Nano),
+			Host:      h.host,
+			Message:   string(line.text),
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	return body.Bytes(), nil
+}
+
+// openHTTP starts shipping the lines to --http-target.
+func (s *pipeline) openHTTP() {
+	if *httpTarget == "" {
+		return
+	}
+
+	var err error
+	s.shipper, err = newHTTPShipper(s.metrics)
+	if err != nil {
+		logFatal(err)
+	}
+}
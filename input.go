@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// source appends the lines of an input until it ends or fails.
+type source func(s *pipeline) error
+
+// stdinInput is the name of stdin, or the command's stdout, in --input.
+const stdinInput = "-"
+
+// labeledInput is an element of the comma separated input flags. Its lines
+// are prefixed with the label if it is given as label=address.
+type labeledInput struct {
+	prefix string
+	addr   string
+}
+
+func splitInputs(value string) []labeledInput {
+	var inputs []labeledInput
+	for _, elem := range strings.Split(value, ",") {
+		if elem == "" {
+			continue
+		}
+		in := labeledInput{addr: elem}
+		if i := strings.Index(elem, "="); i >= 0 {
+			in.prefix, in.addr = elem[:i]+" ", elem[i+1:]
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs
+}
+
+// openInputs checks and opens the inputs given by the flags and the
+// sockets passed by systemd. Stdin, or the stdout of a command, is read if
+// there are no other inputs or if it is given as "-" in --input.
+func openInputs() ([]source, error) {
+	var sources []source
+	readStdin := false
+	for _, in := range splitInputs(*inputFIFO) {
+		in := in
+		if in.addr == stdinInput {
+			readStdin = true
+			sources = append(sources, func(s *pipeline) error { return s.readStdin(in.prefix) })
+			continue
+		}
+		if err := checkFIFO(in.addr); err != nil {
+			return nil, err
+		}
+		sources = append(sources, func(s *pipeline) error {
+			s.readFIFO(in.addr, in.prefix)
+			return nil
+		})
+	}
+	for _, in := range splitInputs(*followFile) {
+		in := in
+		sources = append(sources, func(s *pipeline) error { return s.follow(in.addr, in.prefix) })
+	}
+	for _, in := range splitInputs(*listenTCP) {
+		l, err := net.Listen("tcp", in.addr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot listen for tcp connections: %v", err)
+		}
+		prefix := in.prefix
+		sources = append(sources, func(s *pipeline) error { return s.serveConns(l, "tcp", prefix) })
+	}
+	for _, in := range splitInputs(*listenUnix) {
+		l, err := listenUnixStream(in.addr)
+		if err != nil {
+			return nil, err
+		}
+		prefix := in.prefix
+		sources = append(sources, func(s *pipeline) error { return s.serveConns(l, "unix", prefix) })
+	}
+	for _, network := range []string{"udp", "unixgram"} {
+		value := *listenUDP
+		if network == "unixgram" {
+			value = *listenUnixgram
+		}
+		for _, in := range splitInputs(value) {
+			conn, err := listenDatagrams(network, in.addr)
+			if err != nil {
+				return nil, err
+			}
+			network, prefix := network, in.prefix
+			sources = append(sources, func(s *pipeline) error { return s.serveDatagrams(conn, network, prefix) })
+		}
+	}
+
+	activated, err := systemdSources()
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, activated...)
+
+	// The stdout of a command has to be read, or the command blocks.
+	if !readStdin && (len(sources) == 0 || flag.NArg() > 0) {
+		sources = append(sources, func(s *pipeline) error { return s.readStdin("") })
+	}
+	return sources, nil
+}
+
+// errEndOfInput is returned by the stdin source to stop reading the other
+// sources as well.
+var errEndOfInput = errors.New("end of input")
+
+// readSources appends the lines of all sources until every one ended or
+// stdin ended with --on-eof exit or rotate. The process exits if one of
+// them fails, e.g. because its socket broke.
+func (s *pipeline) readSources(sources []source) {
+	var wg sync.WaitGroup
+	stop := make(chan struct{}, len(sources))
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src source) {
+			defer wg.Done()
+			err := src(s)
+			if err == errEndOfInput {
+				stop <- struct{}{}
+			} else if err != nil {
+				logError(err)
+				s.exitAfterError(exitError)
+			}
+		}(src)
+	}
+
+	ended := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-stop:
+	}
+}
+
+// readStdin appends the lines of stdin, or the stdout of the command, with
+// prefix and applies --on-eof once it ends.
+func (s *pipeline) readStdin(prefix string) error {
+	s.readCompressed(s.stdin, "stdin", prefix)
+	if s.closed.Load() {
+		return nil
+	}
+
+	switch *onEOF {
+	case "wait":
+		// A named pipe can be opened again for the next writer.
+		if path, err := os.Readlink("/proc/self/fd/0"); err == nil && checkFIFO(path) == nil {
+			logInfo("end of stdin, reopening", path)
+			s.readFIFO(path, prefix)
+			return nil
+		}
+		logInfo("end of stdin, waiting for a signal to exit")
+		select {}
+	case "rotate":
+		// The last lines belong into the archive.
+		s.flushQueue()
+		if err := s.appender.Rotate(); err != nil {
+			logError("cannot rotate output:", err)
+		}
+	}
+	return errEndOfInput
+}
+
+// readLines appends the lines of r with prefix until it ends or the
+// pipeline is closed. It returns the error reading r failed with.
+func (s *pipeline) readLines(r io.Reader, prefix string) error {
+	scanner := bufio.NewScanner(r)
+	var buf []byte
+	for scanner.Scan() && !s.closed.Load() {
+		line := scanner.Bytes()
+		if prefix != "" {
+			buf = append(append(buf[:0], prefix...), line...)
+			line = buf
+		}
+		s.AppendBytes(line)
+	}
+	return scanner.Err()
+}
+
+// checkFIFO makes sure --input is a named pipe, as reading a regular file
+// again after every EOF would duplicate its lines.
+func checkFIFO(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot use input: %v", err)
+	}
+	if st.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("cannot use input: %s is not a named pipe", path)
+	}
+	return nil
+}
+
+// readFIFO appends the lines of the named pipe at path with prefix. The pipe is opened
+// again whenever its last writer closed it, which blocks until the next
+// writer opens it.
+func (s *pipeline) readFIFO(path, prefix string) {
+	for !s.closed.Load() {
+		f, err := os.Open(path)
+		if err != nil {
+			logFatal("cannot open input:", err)
+		}
+		logDebug("opened input", path)
+		s.readCompressed(f, path, prefix)
+		f.Close()
+	}
+}
+
+// serveConns appends the lines of every connection accepted by l. It
+// returns only if accepting fails.
+func (s *pipeline) serveConns(l net.Listener, network, prefix string) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("cannot accept %s connection: %v", network, err)
+		}
+		s.metrics.Add(network+".connections", 1)
+		go s.readConn(conn, network, prefix)
+	}
+}
+
+func (s *pipeline) readConn(conn net.Conn, network, prefix string) {
+	defer conn.Close()
+
+	peer := conn.RemoteAddr().String()
+	logDebug("accepted", network, "connection from", peer)
+	if *peerPrefix && network == "tcp" {
+		prefix += peer + " "
+	}
+	s.readLines(conn, prefix)
+	logDebug("closed", network, "connection from", peer)
+}
+
+// listenUnixStream listens for --listen-unix connections at path.
+func listenUnixStream(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for unix connections: %v", err)
+	}
+	if err := chmodSocket(path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeStaleSocket removes the socket at path left behind by a previous
+// run, so listening on it again does not fail. Other files are kept.
+func removeStaleSocket(path string) error {
+	st, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", path, err)
+	}
+	if st.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+// chmodSocket applies --unix-mode to the socket at path, which controls who
+// may connect to it.
+func chmodSocket(path string) error {
+	if *unixMode == "" {
+		return nil
+	}
+	mode, err := strconv.ParseUint(*unixMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("cannot parse --unix-mode: %v", err)
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		return fmt.Errorf("cannot change mode of socket: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"log/syslog"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// benchmarkLine is a line of a typical length for the benchmarks.
+var benchmarkLine = strings.Repeat("x", 199) + "\n"
+
+// BenchmarkReadLines measures a line going from the scanner to the output
+// file, and to the syslog server if it matches the regexp of the case.
+func BenchmarkReadLines(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		syslog bool
+		regexp string
+	}{
+		{"file", false, ""},
+		{"syslog-filtered", true, "^ERROR"},
+		{"syslog", true, ""},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			metrics := rotate.NewMetrics()
+			a, err := rotate.New(rotate.Options{Path: path.Join(b.TempDir(), "app.log"), MaxSize: 1 << 30, Metrics: metrics})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer a.Close()
+			p := &pipeline{appender: a, metrics: metrics}
+			if bench.syslog {
+				// Nothing reads the datagrams, the kernel drops them
+				// once the buffer is full.
+				conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+				w, err := syslog.Dial("udp", conn.LocalAddr().String(), syslog.LOG_INFO, "bench")
+				if err != nil {
+					b.Fatal(err)
+				}
+				p.syslog = newSyslogSender(w, &p.health, metrics, nil)
+			}
+			if bench.regexp != "" {
+				p.regexp = regexp.MustCompile(bench.regexp)
+			}
+			input := bytes.NewReader(bytes.Repeat([]byte(benchmarkLine), b.N))
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(benchmarkLine)))
+			b.ResetTimer()
+			p.readLines(input, "")
+			if p.syslog != nil {
+				// Lines queued for syslog count once they are sent.
+				p.syslog.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// instancePlaceholder is replaced by --instance in the paths of files.
+const instancePlaceholder = "{instance}"
+
+// applyInstance puts --instance into the paths of the files every process
+// has for its own, so several of them can share a directory.
+func applyInstance() error {
+	if *instance == "" {
+		return nil
+	}
+	if strings.ContainsAny(*instance, "/ ") {
+		return fmt.Errorf("--instance must not contain slashes or spaces")
+	}
+	for _, p := range []*string{outputFile, fallbackOutput, stderrOutput, pidfile, rotateMarker, rotateFIFO} {
+		*p = instancePath(*p, *instance)
+	}
+	return nil
+}
+
+// instancePath replaces the placeholder in filePath by name. Without a
+// placeholder name is inserted before the extension, e.g. app.web1.log.
+func instancePath(filePath, name string) string {
+	if filePath == "" || strings.Contains(filePath, instancePlaceholder) {
+		return strings.Replace(filePath, instancePlaceholder, name, -1)
+	}
+	ext := path.Ext(filePath)
+	if ext == path.Base(filePath) {
+		ext = ""
+	}
+	return strings.TrimSuffix(filePath, ext) + "." + name + ext
+}
+
+// prefixInstance returns line prefixed with --instance, in a buffer reused
+// for the next line. It needs s.mu.
+func (s *pipeline) prefixInstance(line []byte) []byte {
+	if s.instancePrefix == nil {
+		return line
+	}
+	s.prefixed = append(append(s.prefixed[:0], s.instancePrefix...), line...)
+	return s.prefixed
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// journalSocket is where journald receives entries in its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalWriter sends lines to the systemd journal as entries with a
+// MESSAGE, PRIORITY and SYSLOG_IDENTIFIER field.
+type journalWriter struct {
+	conn *net.UnixConn
+	buf  bytes.Buffer
+}
+
+func newJournalWriter() (*journalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalWriter{conn: conn}, nil
+}
+
+// Write sends line as one entry with the given priority, 0 (emerg) to
+// 7 (debug), and identifier.
+func (w *journalWriter) Write(line []byte, priority int, identifier string) error {
+	w.buf.Reset()
+	w.field("PRIORITY", []byte(strconv.Itoa(priority)))
+	w.field("SYSLOG_IDENTIFIER", []byte(identifier))
+	w.field("MESSAGE", line)
+	_, err := w.conn.Write(w.buf.Bytes())
+	return err
+}
+
+// field appends a field to the entry. Values containing a newline are
+// prefixed with their length instead of ending at the newline.
+func (w *journalWriter) field(name string, value []byte) {
+	w.buf.WriteString(name)
+	if bytes.IndexByte(value, '\n') < 0 {
+		w.buf.WriteByte('=')
+	} else {
+		w.buf.WriteByte('\n')
+		binary.Write(&w.buf, binary.LittleEndian, uint64(len(value)))
+	}
+	w.buf.Write(value)
+	w.buf.WriteByte('\n')
+}
+
+func (w *journalWriter) Close() error {
+	return w.conn.Close()
+}
+
+// openJournal connects to journald with --journald.
+func (s *pipeline) openJournal() {
+	if !*journald {
+		return
+	}
+
+	var err error
+	s.journal, err = newJournalWriter()
+	if err != nil {
+		logFatal("cannot connect to journald:", err)
+	}
+	if err := s.compileJournalRegexp(); err != nil {
+		logFatal(err)
+	}
+}
+
+func (s *pipeline) compileJournalRegexp() error {
+	if *journaldRegexp == "" {
+		s.journalRegexp = nil
+		return nil
+	}
+
+	re, err := regexp.Compile(*journaldRegexp)
+	if err != nil {
+		return fmt.Errorf("cannot compile journald regexp: %v", err)
+	}
+	s.journalRegexp = re
+	return nil
+}
+
+// sendJournal sends line with priority to journald if it matches
+// --journald-regexp.
+func (s *pipeline) sendJournal(line []byte, priority int) {
+	if s.journalRegexp != nil && !s.journalRegexp.Match(line) {
+		s.metrics.Add("journald.filtered", 1)
+		return
+	}
+
+	if err := s.journal.Write(line, priority, *journaldIdent); err != nil {
+		s.metrics.Add("journald.errors", 1)
+		logDebug("cannot send line to journald:", err)
+		return
+	}
+	s.metrics.Add("journald.lines", 1)
+}
+
+// checkJournal reports whether journald can be reached for --check.
+func checkJournal() error {
+	w, err := newJournalWriter()
+	if err != nil {
+		return fmt.Errorf("cannot connect to journald: %v", err)
+	}
+	return w.Close()
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+const (
+	kafkaAPIProduce  = 0
+	kafkaAPIMetadata = 3
+
+	kafkaClientID   = "stdin-rotate"
+	kafkaQueueSize  = 10000
+	kafkaBatchSize  = 500
+	kafkaBatchDelay = 100 * time.Millisecond
+	kafkaTimeout    = 10 * time.Second
+)
+
+var kafkaCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaProducer sends lines to a Kafka topic in batches. It speaks just
+// enough of the Kafka protocol (Metadata v1 and Produce v3) to find the
+// partition leaders and append record batches to them, so no client
+// library is needed.
+type kafkaProducer struct {
+	brokers []string
+	topic   string
+	metrics *rotate.Metrics
+
+	lines     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   int64
+
+	leaders       map[int32]string
+	partitions    []int32
+	conns         map[string]*kafkaConn
+	nextPartition int
+	correlationID int32
+}
+
+type kafkaConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newKafkaProducer(brokers []string, topic string, metrics *rotate.Metrics) (*kafkaProducer, error) {
+	p := &kafkaProducer{
+		brokers: brokers,
+		topic:   topic,
+		metrics: metrics,
+		lines:   make(chan []byte, kafkaQueueSize),
+		done:    make(chan struct{}),
+		conns:   make(map[string]*kafkaConn),
+	}
+	if err := p.refreshMetadata(); err != nil {
+		return nil, err
+	}
+
+	go p.run()
+	return p, nil
+}
+
+// Write queues line to be sent with the next batch. It never blocks; lines
+// are dropped if the queue is full because Kafka is slow or unreachable.
+func (p *kafkaProducer) Write(line []byte) (int, error) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case p.lines <- buf:
+	default:
+		atomic.AddInt64(&p.dropped, 1)
+		p.metrics.Add("kafka.dropped", 1)
+	}
+	return len(line), nil
+}
+
+// Close sends the remaining queued lines and closes the broker connections.
+// Calling it again waits for the first call to finish.
+func (p *kafkaProducer) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.lines)
+		<-p.done
+	})
+	return nil
+}
+
+func (p *kafkaProducer) run() {
+	defer close(p.done)
+	defer p.closeConns()
+
+	ticker := time.NewTicker(kafkaBatchDelay)
+	defer ticker.Stop()
+
+	batch := [][]byte{}
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, line)
+			if len(batch) >= kafkaBatchSize {
+				p.flush(batch)
+				batch = [][]byte{}
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = [][]byte{}
+		}
+	}
+}
+
+func (p *kafkaProducer) flush(batch [][]byte) {
+	if dropped := atomic.SwapInt64(&p.dropped, 0); dropped > 0 {
+		logEvent(levelWarn, "kafka_dropped", logFields{"lines": dropped}, "kafka queue full, dropped", dropped, "lines")
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	err := p.produce(batch)
+	if err != nil {
+		// The leader may have moved, so try once more with fresh metadata.
+		p.closeConns()
+		if err = p.refreshMetadata(); err == nil {
+			err = p.produce(batch)
+		}
+	}
+	if err != nil {
+		logEvent(levelError, "kafka_failed", logFields{"lines": len(batch), "error": err.Error()}, "cannot send", len(batch), "lines to kafka:", err)
+		p.metrics.Add("kafka.errors", 1)
+	}
+}
+
+func (p *kafkaProducer) produce(batch [][]byte) error {
+	partition := p.partitions[p.nextPartition%len(p.partitions)]
+	p.nextPartition++
+
+	addr, ok := p.leaders[partition]
+	if !ok {
+		return fmt.Errorf("no leader for partition %d", partition)
+	}
+	c, err := p.conn(addr)
+	if err != nil {
+		return err
+	}
+
+	req := kafkaProduceRequest(p.topic, partition, kafkaRecordBatch(batch, time.Now()))
+	resp, err := p.request(c, kafkaAPIProduce, 3, req)
+	if err != nil {
+		return err
+	}
+
+	d := kafkaDecoder{buf: resp}
+	for topics := d.int32(); topics > 0; topics-- {
+		d.string()
+		for partitions := d.int32(); partitions > 0; partitions-- {
+			d.int32()
+			code := d.int16()
+			d.int64()
+			d.int64()
+			if code != 0 {
+				return fmt.Errorf("produce failed with error code %d", code)
+			}
+		}
+	}
+	return d.err
+}
+
+func (p *kafkaProducer) refreshMetadata() error {
+	var lastErr error
+	for _, addr := range p.brokers {
+		c, err := p.conn(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var req kafkaEncoder
+		req.int32(1)
+		req.string(p.topic)
+		resp, err := p.request(c, kafkaAPIMetadata, 1, req.Bytes())
+		if err != nil {
+			p.closeConn(addr)
+			lastErr = err
+			continue
+		}
+
+		return p.parseMetadata(resp)
+	}
+
+	if lastErr == nil {
+		lastErr = errors.New("no kafka brokers given")
+	}
+	return lastErr
+}
+
+func (p *kafkaProducer) parseMetadata(resp []byte) error {
+	d := kafkaDecoder{buf: resp}
+
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+
+	leaders := make(map[int32]string)
+	partitions := []int32{}
+	for n := d.int32(); n > 0; n-- {
+		code := d.int16()
+		name := d.string()
+		d.int8() // is internal
+		if code != 0 && d.err == nil {
+			return fmt.Errorf("metadata for topic %s failed with error code %d", name, code)
+		}
+		for m := d.int32(); m > 0; m-- {
+			d.int16() // partition error code
+			partition := d.int32()
+			leader := d.int32()
+			for r := d.int32(); r > 0; r-- {
+				d.int32()
+			}
+			for r := d.int32(); r > 0; r-- {
+				d.int32()
+			}
+			if addr, ok := brokers[leader]; ok {
+				leaders[partition] = addr
+				partitions = append(partitions, partition)
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(partitions) == 0 {
+		return fmt.Errorf("no partitions with a leader for topic %s", p.topic)
+	}
+
+	p.leaders = leaders
+	p.partitions = partitions
+	return nil
+}
+
+func (p *kafkaProducer) conn(addr string) (*kafkaConn, error) {
+	if c, ok := p.conns[addr]; ok {
+		return c, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, kafkaTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &kafkaConn{conn: conn, reader: bufio.NewReader(conn)}
+	p.conns[addr] = c
+	return c, nil
+}
+
+func (p *kafkaProducer) closeConn(addr string) {
+	if c, ok := p.conns[addr]; ok {
+		c.conn.Close()
+		delete(p.conns, addr)
+	}
+}
+
+func (p *kafkaProducer) closeConns() {
+	for addr := range p.conns {
+		p.closeConn(addr)
+	}
+}
+
+// request sends a size delimited request with the common header and returns
+// the response body following the correlation id.
+func (p *kafkaProducer) request(c *kafkaConn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	p.correlationID++
+
+	var req kafkaEncoder
+	req.int32(0) // size, filled in below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(p.correlationID)
+	req.string(kafkaClientID)
+	req.Write(body)
+	buf := req.Bytes()
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(kafkaTimeout))
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	var size int32
+	if err := binary.Read(c.reader, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 4 {
+		return nil, fmt.Errorf("invalid kafka response size %d", size)
+	}
+	resp := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, resp); err != nil {
+		return nil, err
+	}
+	if id := int32(binary.BigEndian.Uint32(resp)); id != p.correlationID {
+		return nil, fmt.Errorf("kafka correlation id mismatch: got %d, expected %d", id, p.correlationID)
+	}
+	return resp[4:], nil
+}
+
+// kafkaProduceRequest encodes the body of a Produce v3 request appending
+// the record batch records to a partition of topic.
+func kafkaProduceRequest(topic string, partition int32, records []byte) []byte {
+	var req kafkaEncoder
+	req.int16(-1) // no transactional id
+	req.int16(1)  // acks from the leader only
+	req.int32(int32(kafkaTimeout / time.Millisecond))
+	req.int32(1)
+	req.string(topic)
+	req.int32(1)
+	req.int32(partition)
+	req.int32(int32(len(records)))
+	req.Write(records)
+	return req.Bytes()
+}
+
+// kafkaRecordBatch encodes lines as a v2 record batch with null keys.
+func kafkaRecordBatch(lines [][]byte, now time.Time) []byte {
+	var records kafkaEncoder
+	for i, line := range lines {
+		var rec kafkaEncoder
+		rec.int8(0)          // attributes
+		rec.varint(0)        // timestamp delta
+		rec.varint(int64(i)) // offset delta
+		rec.varint(-1)       // null key
+		rec.varint(int64(len(line)))
+		rec.Write(line)
+		rec.varint(0) // no headers
+
+		records.varint(int64(rec.Len()))
+		records.Write(rec.Bytes())
+	}
+
+	ts := now.UnixNano() / int64(time.Millisecond)
+
+	// Everything from the attributes on is covered by the CRC.
+	var body kafkaEncoder
+	body.int16(0) // attributes: no compression
+	body.int32(int32(len(lines) - 1))
+	body.int64(ts)
+	body.int64(ts)
+	body.int64(-1) // producer id
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(lines)))
+	body.Write(records.Bytes())
+
+	var batch kafkaEncoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(body.Bytes(), kafkaCRCTable)))
+	batch.Write(body.Bytes())
+	return batch.Bytes()
+}
+
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.Write(b[:n])
+}
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+// kafkaDecoder reads big endian fields from buf, remembering the first
+// error so callers only have to check it once at the end.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errors.New("short kafka response")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a (nullable) string; null is returned as empty.
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n <= 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
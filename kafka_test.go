@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestKafkaProduceRequest compares a Produce v3 request with a v2 record
+// batch of two lines to the bytes the Kafka protocol specifies.
+func TestKafkaProduceRequest(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	got := kafkaProduceRequest("logs", 2, kafkaRecordBatch([][]byte{[]byte("hello"), []byte("world!")}, now))
+
+	want, err := hex.DecodeString(strings.Join([]string{
+		// Produce v3
+		"ffff",             // no transactional id
+		"0001",             // acks
+		"00002710",         // timeout of 10000 ms
+		"00000001",         // one topic
+		"0004", "6c6f6773", // "logs"
+		"00000001", // one partition
+		"00000002", // partition 2
+		"00000056", // size of the record batch
+		// record batch v2
+		"0000000000000000",           // base offset
+		"0000004a",                   // length
+		"ffffffff",                   // partition leader epoch
+		"02",                         // magic
+		"8fdb15b2",                   // CRC-32C of the rest
+		"0000",                       // attributes
+		"00000001",                   // last offset delta
+		"0000016f6435cc8e",           // first timestamp
+		"0000016f6435cc8e",           // max timestamp
+		"ffffffffffffffff",           // producer id
+		"ffff",                       // producer epoch
+		"ffffffff",                   // base sequence
+		"00000002",                   // two records
+		"16", "00", "00", "00", "01", // length, attributes, timestamp and offset delta, null key
+		"0a", "68656c6c6f", "00", // "hello", no headers
+		"18", "00", "00", "02", "01",
+		"0c", "776f726c6421", "00", // "world!"
+	}, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("request =\n%x\nwant\n%x", got, want)
+	}
+}
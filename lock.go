@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// lockSuffix is appended to --output for the lock file.
+const lockSuffix = ".lock"
+
+// outputLock is kept open, and so locked, until the process exits.
+var outputLock *os.File
+
+// lockOutput takes an exclusive lock next to path, so a second instance
+// cannot rotate the same output and mix up its archives. The lock is on a
+// separate file as the output is renamed on rotation. A followed symbolic
+// link is locked next to its target, which is what gets rotated. With
+// --shared-output the lock is shared with the other instances using it, and
+// only keeps out those without.
+func lockOutput(path string) error {
+	if *outputSymlink == "follow" {
+		if target, err := rotate.SymlinkTarget(path); err == nil {
+			path = target
+		}
+	}
+	f, err := os.OpenFile(path+lockSuffix, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot lock output: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), lockMode()|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK && *sharedOutput {
+			return fmt.Errorf("cannot lock output: %s is used by another instance without --shared-output", path)
+		}
+		if err == syscall.EWOULDBLOCK {
+			return fmt.Errorf("cannot lock output: %s is used by another instance", path)
+		}
+		return fmt.Errorf("cannot lock output: %v", err)
+	}
+	outputLock = f
+	return nil
+}
+
+// checkLock reports for --check whether another instance holds the lock.
+func checkLock(path string) error {
+	f, err := os.Open(path + lockSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot check lock: %v", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), lockMode()|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+		return fmt.Errorf("%s is used by another instance", path)
+	}
+	return nil
+}
+
+// lockMode returns how lockOutput locks the output.
+func lockMode() int {
+	if *sharedOutput {
+		return syscall.LOCK_SH
+	}
+	return syscall.LOCK_EX
+}
+
+// checkSharedOutput validates the flags of --shared-output, which cannot
+// coordinate the instances with the features that rewrite or lock the
+// output in their own way.
+func checkSharedOutput() error {
+	if !*sharedOutput {
+		return nil
+	}
+	for _, conflict := range []struct {
+		name string
+		set  bool
+	}{
+		{"--encrypt-live", *encryptLive},
+		{"--rotate-copytruncate", *rotateCopyTrunc},
+		{"--nfs-safe", *nfsSafe},
+		{"--direct-io", *directIO},
+		{"--preallocate", *preallocate},
+		{"--rotate-on-start", *rotateOnStart},
+		{"--truncate-on-start", *truncOnStart},
+		{"--copytruncate", *copyTrunc},
+		{"--number-lines", *numberLines},
+	} {
+		if conflict.set {
+			return fmt.Errorf("--shared-output cannot be used with %s", conflict.name)
+		}
+	}
+	return nil
+}
+
+// writePidfile writes our process id to --pidfile.
+func writePidfile() error {
+	if *pidfile == "" {
+		return nil
+	}
+	if err := ioutil.WriteFile(*pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return fmt.Errorf("cannot write pidfile: %v", err)
+	}
+	return nil
+}
+
+// exit removes --pidfile and exits with status, or with the status of the
+// first failure and after printing their summary with --strict.
+func exit(status int) {
+	if *strict {
+		status = failures.print(status)
+	}
+	if *pidfile != "" {
+		os.Remove(*pidfile)
+	}
+	os.Exit(status)
+}
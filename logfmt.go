@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// severities are the syslog severities of the level names used in logs.
+var severities = map[string]int{
+	"emerg":    0,
+	"panic":    0,
+	"alert":    1,
+	"crit":     2,
+	"critical": 2,
+	"fatal":    2,
+	"err":      3,
+	"error":    3,
+	"warn":     4,
+	"warning":  4,
+	"notice":   5,
+	"info":     6,
+	"debug":    7,
+	"trace":    7,
+}
+
+// lineSeverity returns the syslog severity of the --logfmt-level field of
+// line. It reports false if line has no such field or an unknown level,
+// so the regexps decide for it.
+func lineSeverity(line []byte) (int, bool) {
+	if *logfmtLevel == "" {
+		return 0, false
+	}
+	value, ok := logfmtValue(line, *logfmtLevel)
+	if !ok {
+		return 0, false
+	}
+	severity, ok := severities[strings.ToLower(value)]
+	return severity, ok
+}
+
+// logfmtValue returns the value of key in a logfmt line like
+// `level=error msg="cannot connect"`, unquoting it if it is quoted.
+func logfmtValue(line []byte, key string) (string, bool) {
+	for len(line) > 0 {
+		line = bytes.TrimLeft(line, " ")
+		end := bytes.IndexAny(line, "= ")
+		if end < 0 {
+			return "", false
+		}
+		name := line[:end]
+		line = line[end:]
+		if line[0] != '=' {
+			continue
+		}
+		line = line[1:]
+
+		var value []byte
+		if len(line) > 0 && line[0] == '"' {
+			i := 1
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(line) {
+				return "", false
+			}
+			value, line = line[:i+1], line[i+1:]
+		} else {
+			end := bytes.IndexByte(line, ' ')
+			if end < 0 {
+				end = len(line)
+			}
+			value, line = line[:end], line[end:]
+		}
+		if string(name) != key {
+			continue
+		}
+		if len(value) > 0 && value[0] == '"' {
+			unquoted, err := strconv.Unquote(string(value))
+			return unquoted, err == nil
+		}
+		return string(value), true
+	}
+	return "", false
+}
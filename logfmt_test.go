@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestLogfmtValue(t *testing.T) {
+	for _, test := range []struct {
+		line, key string
+		value     string
+		ok        bool
+	}{
+		{`level=error msg="cannot connect"`, "level", "error", true},
+		{`level=error msg="cannot connect"`, "msg", "cannot connect", true},
+		{`msg="cannot connect" level=warn`, "level", "warn", true},
+		{`  level=info  `, "level", "info", true},
+		{`level=`, "level", "", true},
+		{`level="" msg=x`, "level", "", true},
+		{`msg="say \"hi\"" level=info`, "msg", `say "hi"`, true},
+		{`msg="a\\b" level=info`, "level", "info", true},
+		{`msg="a\\b"`, "msg", `a\b`, true},
+		{`msg="tab\there"`, "msg", "tab\there", true},
+		{`msg="level=error" level=debug`, "level", "debug", true},
+		{`loglevel=error level=info`, "level", "info", true},
+		{`started level=info`, "level", "info", true},
+		{`level=info`, "lvl", "", false},
+		{`plain text line`, "level", "", false},
+		{``, "level", "", false},
+		{`msg="unterminated level=error`, "level", "", false},
+		{`level="bad \q escape"`, "level", "", false},
+	} {
+		value, ok := logfmtValue([]byte(test.line), test.key)
+		if value != test.value || ok != test.ok {
+			t.Errorf("logfmtValue(%q, %q) = %q, %v, want %q, %v", test.line, test.key, value, ok, test.value, test.ok)
+		}
+	}
+}
+
+func TestLineSeverity(t *testing.T) {
+	defer func(level string) { *logfmtLevel = level }(*logfmtLevel)
+
+	*logfmtLevel = ""
+	if _, ok := lineSeverity([]byte("level=error")); ok {
+		t.Error("lineSeverity found a level without --logfmt-level")
+	}
+
+	*logfmtLevel = "level"
+	for _, test := range []struct {
+		line     string
+		severity int
+		ok       bool
+	}{
+		{"level=error msg=x", 3, true},
+		{"level=ERROR", 3, true},
+		{`level="Warning"`, 4, true},
+		{"level=debug", 7, true},
+		{"level=fatal", 2, true},
+		{"level=verbose", 0, false},
+		{"msg=x", 0, false},
+	} {
+		severity, ok := lineSeverity([]byte(test.line))
+		if severity != test.severity || ok != test.ok {
+			t.Errorf("lineSeverity(%q) = %d, %v, want %d, %v", test.line, severity, ok, test.severity, test.ok)
+		}
+	}
+}
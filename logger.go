@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// logLevel orders internal messages by severity. Fatal errors are always
+// logged regardless of the level.
+type logLevel int
+
+const (
+	levelDebug = logLevel(rotate.LevelDebug)
+	levelInfo  = logLevel(rotate.LevelInfo)
+	levelWarn  = logLevel(rotate.LevelWarn)
+	levelError = logLevel(rotate.LevelError)
+	levelFatal = levelError + 1
+)
+
+var levelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+var levelPrefixes = map[logLevel]string{
+	levelDebug: "DEBUG:",
+	levelInfo:  "INFO:",
+	levelWarn:  "WARN:",
+	levelError: "ERROR:",
+	levelFatal: "ERROR:",
+}
+
+// logFields carries the structured context of an event. It is only rendered
+// with --log-format json, text messages are expected to mention what matters.
+type logFields map[string]interface{}
+
+var (
+	currentLevel = levelInfo
+	jsonLogs     bool
+
+	logMu     sync.Mutex
+	logOutput io.Writer = os.Stderr
+)
+
+// setupLogging applies the --log-level, --log-format and --log-file flags.
+func setupLogging(level, format, file string) error {
+	if err := setLogLevel(level); err != nil {
+		return err
+	}
+
+	switch format {
+	case "text":
+	case "json":
+		jsonLogs = true
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		logOutput = f
+		log.SetOutput(f)
+	}
+	return nil
+}
+
+func setLogLevel(name string) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	currentLevel = level
+	return nil
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+func logDebug(v ...interface{}) { logAt(3, levelDebug, "", nil, v...) }
+func logInfo(v ...interface{})  { logAt(3, levelInfo, "", nil, v...) }
+func logWarn(v ...interface{})  { logAt(3, levelWarn, "", nil, v...) }
+func logError(v ...interface{}) { logAt(3, levelError, "", nil, v...) }
+
+// logFatal logs an error and exits the process with status 1.
+func logFatal(v ...interface{}) {
+	logAt(3, levelFatal, "", nil, v...)
+	os.Exit(1)
+}
+
+// logEvent logs a message of the given event type together with fields.
+func logEvent(level logLevel, event string, fields logFields, v ...interface{}) {
+	logAt(3, level, event, fields, v...)
+}
+
+// rotateLogger passes the diagnostics of the rotate package to the leveled
+// logger.
+type rotateLogger struct{}
+
+func (rotateLogger) Log(level rotate.Level, event string, fields rotate.Fields, msg string) {
+	// Skip the Appender's log helper as well to report its caller.
+	logAt(4, logLevel(level), event, logFields(fields), msg)
+}
+
+// logAt logs at level, reporting the file of the caller depth frames up as
+// counted by log.Output.
+func logAt(depth int, level logLevel, event string, fields logFields, v ...interface{}) {
+	if level < currentLevel {
+		return
+	}
+
+	if !jsonLogs {
+		log.Output(depth, fmt.Sprintln(append([]interface{}{levelPrefixes[level]}, v...)...))
+		return
+	}
+
+	entry := logFields{}
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = strings.ToLower(strings.TrimSuffix(levelPrefixes[level], ":"))
+	if level == levelFatal {
+		entry["level"] = "fatal"
+	}
+	entry["msg"] = strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	if event != "" {
+		entry["event"] = event
+	}
+	if _, file, line, ok := runtime.Caller(depth - 1); ok {
+		entry["caller"] = fmt.Sprintf("%s:%d", path.Base(file), line)
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		buf, _ = json.Marshal(logFields{"level": "error", "msg": err.Error()})
+	}
+
+	logMu.Lock()
+	logOutput.Write(append(buf, '\n'))
+	logMu.Unlock()
+}
@@ -1,211 +1,975 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"log/syslog"
 	"os"
 	"os/signal"
 	"path"
 	"regexp"
-	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// Exit statuses besides 0 for success. Those from exitDiskFull on are only
+// used by --strict.
+const (
+	exitError          = 1
+	exitWriteFailed    = 3
+	exitStalled        = 4
+	exitDiskFull       = 5
+	exitPermission     = 6
+	exitRotateFailed   = 7
+	exitCompressFailed = 8
+	exitDeleteFailed   = 9
+	exitSyslogFailed   = 10
 )
 
 var (
-	compressOld    = flag.Bool("gzip", false, "Gzip old files")
-	outputFile     = flag.String("output", "./output.log", "Output file")
-	maxFiles       = flag.Int("max-files", 5, "Maximum files to preserve")
-	maxFileSize    = flag.Int("max-size", 10*1024*1024, "Maximum file size")
-	syslogTarget   = flag.String("syslog-target", "", "Syslog server:port to send --syslog-regexp matching lines")
-	syslogRegexp   = flag.String("syslog-regexp", "", "Regular expression to match lines against to send them to syslog server")
-	syslogPriority = flag.Int("syslog-priority", int(syslog.LOG_NOTICE|syslog.LOG_LOCAL2), "Syslog priority")
-	syslogTag      = flag.String("syslog-tag", "stdin-rotate", "Syslog tag")
+	compressOld     = flag.Bool("gzip", false, "Gzip old files")
+	compressRate    = rateVar("compress-bandwidth", 0, "Limit compression to reading this `rate` in bytes per second, or with a unit like 10MB/s, 0 for no limit")
+	compressNice    = flag.Bool("compress-nice", false, "Compress with the lowest CPU priority and idle I/O priority, like nice and ionice -c3")
+	compressQueue   = flag.Int("compress-queue-size", rotate.DefaultQueueSize, "How many archives may wait for compression and removal of old archives")
+	compressWindow  = flag.String("compress-window", "", "Daily time range like 01:00-05:00 in local time to compress archives in, leaving those rotated outside of it until it opens, also across restarts")
+	compressPolicy  = flag.String("compress-queue-policy", "block", "What to do with archives rotated while the compression queue is full: block writing, skip compressing them or drop-oldest to leave the oldest waiting one uncompressed")
+	keepPlain       = flag.Int("keep-uncompressed", 0, "Number of newest archives to leave uncompressed with --gzip, for grepping them")
+	delayCompress   = flag.Bool("delay-compress", false, "Compress an archive only at the next rotation with --gzip, like logrotate's delaycompress")
+	outputFile      = flag.String("output", "./output.log", "Output file")
+	noFile          = flag.Bool("no-file", false, "Only forward the lines to syslog, Kafka, journald and the other forwarding outputs, without writing --output or any other file")
+	configFile      = flag.String("config", "", "File of name = value lines to set flags from, reloaded on SIGHUP")
+	checkOnly       = flag.Bool("check", false, "Validate the configuration and exit without reading stdin (0: valid, 1: invalid flags, 2: environment problems)")
+	maxFiles        = flag.Int("max-files", 5, "Maximum files to preserve, 0 or -1 to never delete any")
+	noCleanup       = flag.Bool("no-cleanup", false, "Never delete archives, leaving retention to another tool")
+	maxAge          = ageVar("max-age", 0, "Delete archives rotated longer ago than this `age`, like 14d or 36h, 0 to keep them regardless of their age")
+	maxPerDay       = flag.Int("max-files-per-day", 0, "Maximum archives to preserve of each day, removing the oldest ones of a day beyond it before --max-files counts the rest, 0 for no limit")
+	maxTotalSize    = sizeVar("max-total-size", 0, "Remove the oldest archives while they take more than this `size` together, after the other retention flags, 0 for no limit")
+	retentionMode   = flag.String("retention-policy", "and", "How --max-files and --max-age combine if both are set: and keeps only what both keep, or keeps what either keeps")
+	metadata        = flag.Bool("metadata", false, "Write ARCHIVE.meta.json next to each archive with its time range, host, line count and size")
+	manifest        = flag.Bool("manifest", false, "Maintain OUTPUT.manifest.json listing the archives with their time ranges, line counts, sizes and checksums")
+	retentionDry    = flag.Bool("retention-dry-run", false, "Log which archives --max-files would remove at startup and after every rotation instead of removing them")
+	maxFileSize     = sizeVar("max-size", 10*1024*1024, "Maximum file `size` in bytes, or with a unit K, M, G or T, e.g. 100M")
+	syslogTarget    = flag.String("syslog-target", "", "Syslog server:port to send --syslog-regexp matching lines")
+	syslogRegexp    = flag.String("syslog-regexp", "", "Regular expression to match lines against to send them to syslog server")
+	syslogPriority  = flag.Int("syslog-priority", int(syslog.LOG_NOTICE|syslog.LOG_LOCAL2), "Syslog priority")
+	syslogMinLevel  = flag.String("syslog-min-level", "", "Send the lines whose --logfmt-level is at least this severe, e.g. warn, to syslog instead of matching --syslog-regexp, which still decides for lines without a level")
+	logfmtLevel     = flag.String("logfmt-level", "", "Field of logfmt lines holding their level, e.g. level, to send them to syslog and journald with that severity")
+	syslogTag       = flag.String("syslog-tag", "stdin-rotate", "Syslog tag")
+	journald        = flag.Bool("journald", false, "Send --journald-regexp matching lines to the systemd journal")
+	journaldRegexp  = flag.String("journald-regexp", "", "Regular expression to match lines against to send them to journald, all lines if empty")
+	journaldPrio    = flag.Int("journald-priority", 5, "Priority of the lines sent to journald, from 0 (emerg) to 7 (debug)")
+	journaldIdent   = flag.String("journald-identifier", "stdin-rotate", "SYSLOG_IDENTIFIER of the lines sent to journald")
+	encryptRcpt     = flag.String("encrypt-recipient", "", "Comma separated age public keys or GPG key ids to encrypt archives for")
+	encryptLive     = flag.Bool("encrypt-live", false, "Encrypt --output itself for --encrypt-recipient while writing it, so no plaintext reaches the disk")
+	checksum        = flag.Bool("checksum", false, "Write a .sha256 checksum file next to each archive")
+	verifyChecksum  = flag.Bool("verify-checksum", false, "Verify archives against their checksum files before removing them, keeping the ones that do not match")
+	kafkaBrokers    = flag.String("kafka-brokers", "", "Comma separated Kafka broker host:port list to send --kafka-regexp matching lines")
+	kafkaTopic      = flag.String("kafka-topic", "", "Kafka topic to send lines to")
+	kafkaRegexp     = flag.String("kafka-regexp", "", "Regular expression to match lines against to send them to Kafka")
+	gelfTarget      = flag.String("gelf-target", "", "Graylog server:port to send --gelf-regexp matching lines to as GELF messages")
+	gelfProtocol    = flag.String("gelf-protocol", "udp", "Protocol to send GELF messages with: udp, chunking large ones, or tcp")
+	gelfRegexp      = flag.String("gelf-regexp", "", "Regular expression to match lines against to send them to Graylog, all lines if empty")
+	forwardTCP      = flag.String("forward-tcp", "", "Host:port to mirror every line to over TCP, each followed by a newline, reconnecting whenever the connection breaks")
+	forwardBuffer   = flag.Int("forward-tcp-buffer", 10000, "Number of lines to keep for --forward-tcp while it is slow or disconnected before dropping further ones")
+	fluentdTarget   = flag.String("fluentd-target", "", "Fluentd or Fluent Bit host:port to send every line to with the forward protocol")
+	fluentdTag      = flag.String("fluentd-tag", "stdin-rotate", "Tag of the events sent to --fluentd-target")
+	fluentdAck      = flag.Bool("fluentd-ack", false, "Wait for --fluentd-target to acknowledge each batch of lines and send it again if it does not")
+	httpTarget      = flag.String("http-target", "", "URL of the Loki push API or the Elasticsearch bulk API to post batches of lines to")
+	httpFormat      = flag.String("http-format", "loki", "API of --http-target: loki or elasticsearch")
+	httpLabels      = flag.String("http-labels", "job=stdin-rotate", "Comma separated name=value labels of the Loki stream")
+	httpIndex       = flag.String("http-index", "stdin-rotate-{date}", "Elasticsearch index to add the lines to, {date} is replaced by the day like 2006.01.02")
+	httpBatchSize   = flag.Int("http-batch-size", 1000, "Number of lines to post at most in one request to --http-target")
+	httpInterval    = flag.Duration("http-batch-interval", time.Second, "Interval to post the lines read since the last request to --http-target")
+	statsdTarget    = flag.String("statsd-target", "", "Statsd server:port to push metrics to")
+	statsdPrefix    = flag.String("statsd-prefix", "stdin-rotate.", "Prefix for statsd metric names")
+	statsdTags      = flag.String("statsd-tags", "", "Comma separated DogStatsD tags (key:value) to add to the metrics")
+	statsdInterval  = flag.Duration("statsd-interval", 10*time.Second, "Interval to push metrics to statsd")
+	statsInterval   = flag.Duration("stats-interval", 0, "Interval to print a stats line to stderr or --stats-file, 0 to disable")
+	statsFile       = flag.String("stats-file", "", "File to append the stats lines to instead of stderr")
+	healthListen    = flag.String("health-listen", "", "Address to serve the /healthz endpoint on, e.g. :8080")
+	healthMaxAge    = flag.Duration("health-max-age", 0, "Report unhealthy if no line was written for this long, 0 to disable")
+	stallTimeout    = flag.Duration("stall-timeout", 0, "Log an error and report unhealthy if input is waiting but no line could be written for this long, 0 to disable")
+	stallExit       = flag.Bool("stall-exit", false, "Exit with status 4 after --stall-timeout, so a supervisor can restart stdin-rotate")
+	heartbeat       = flag.Duration("heartbeat", 0, "Append --heartbeat-line to the output this often, even while no lines arrive, 0 to disable")
+	heartbeatLine   = flag.String("heartbeat-line", "stdin-rotate heartbeat {time}", "Marker line appended by --heartbeat, {time} is replaced by the current time in RFC 3339")
+	pprofListen     = flag.String("pprof-listen", "", "Address to serve the net/http/pprof endpoints on, e.g. localhost:6060")
+	logLevelName    = flag.String("log-level", "info", "Level of internal messages: debug, info, warn or error")
+	logFormat       = flag.String("log-format", "text", "Format of internal messages: text or json")
+	logFile         = flag.String("log-file", "", "File to append internal messages to instead of stderr")
+	onError         = flag.String("on-error", "continue", "What to do after write, compression or deletion errors: continue or exit")
+	strict          = flag.Bool("strict", false, "Exit after any write, rotation, compression, deletion or syslog error with a status telling them apart, and print a summary of the failures on exit")
+	copyTrunc       = flag.Bool("copytruncate", false, "Rotate --output written by another program by copying and truncating it instead of reading lines")
+	copyInterval    = flag.Duration("copytruncate-interval", 10*time.Second, "How often to check the size of --output with --copytruncate")
+	pidfile         = flag.String("pidfile", "", "File to write the process id to while running")
+	daemon          = flag.Bool("daemon", false, "Detach from the terminal and run in the background, printing the process id once it is written to --pidfile")
+	daemonLog       = flag.String("daemon-log", os.DevNull, "File to redirect stderr to with --daemon")
+	inputCompress   = flag.String("input-compression", "auto", "Decompress stdin and --input pipes: auto to detect gzip and zstd by their magic number, gzip, zstd with the zstd binary, or none")
+	onEOF           = flag.String("on-eof", "exit", "What to do when stdin or the command's stdout ends: exit, wait for a signal, reopening stdin if it is a named pipe, or rotate the output and exit")
+	flushRegexp     = flag.String("flush-on-regexp", "", "Regular expression to match lines against to sync the output to the disk right after writing them, e.g. for commit records")
+	writeRetries    = flag.Int("write-retries", 3, "How often to retry a failed write before the line is lost")
+	retryDelay      = flag.Duration("write-retry-delay", rotate.DefaultRetryDelay, "Delay before the first retry of a failed write, doubled for every further one")
+	fallbackOutput  = flag.String("fallback-output", "", "Output file to use while --output cannot be written to")
+	fallbackRetry   = flag.Duration("fallback-retry", rotate.DefaultFallbackRetry, "How often to check whether --output can be written to again while using --fallback-output")
+	statInterval    = flag.Duration("stat-interval", time.Second, "How often to check whether the output file was deleted, truncated or appended to by others and take over its size, 0 to disable")
+	outputSymlink   = flag.String("output-symlink", "follow", "What to do if --output is a symbolic link: follow it to write and rotate its target, or replace it with a regular file")
+	syncWrites      = flag.Bool("sync-writes", false, "Open the output with O_DSYNC, so every line is on the disk before the next one is read")
+	directIO        = flag.Bool("direct-io", false, "Write the output with O_DIRECT on Linux, bypassing the page cache, in blocks of 1 MiB")
+	preallocate     = flag.Bool("preallocate", false, "Reserve --max-size of disk space for each output file when it is opened, with fallocate on Linux, freeing the rest at rotation")
+	rotateCopyTrunc = flag.Bool("rotate-copytruncate", false, "Rotate by copying --output to the archive and truncating it instead of renaming it, so readers keeping it open, like tail -f, do not lose it")
+	nfsSafe         = flag.Bool("nfs-safe", false, "Rotate by copying, syncing and removing instead of renaming, retry ESTALE errors and do not lock --output, for NFS and other network filesystems")
+	archiveUTC      = flag.Bool("archive-utc", false, "Use UTC for the timestamps of archive names, ending in Z, instead of the local time")
+	archiveSeq      = flag.Bool("archive-sequence", false, "Put an increasing number saved in OUTPUT.seq in front of the timestamp of archive names and order archives by it, so clock steps do not reorder them")
+	archiveNumbers  = flag.Bool("archive-numbered", false, "Name the archives like logrotate, OUTPUT.1, OUTPUT.2.gz and so on, renaming all of them on every rotation")
+	rotateMarker    = flag.String("rotate-marker", "", "File to overwrite with a JSON line about the archive on every rotation of --output, for programs watching it with inotify")
+	rotateFIFO      = flag.String("rotate-fifo", "", "Named pipe, created if missing, to write a JSON line about the archive to on every rotation of --output while a program reads it")
+	sharedOutput    = flag.Bool("shared-output", false, "Let several instances append to the same --output, the first to find it full rotating it for all")
+	rotateOnStart   = flag.Bool("rotate-on-start", false, "Archive --output at startup if it is not empty, so every run has its own archives")
+	truncOnStart    = flag.Bool("truncate-on-start", false, "Start with an empty --output instead of appending to what the previous run left in it, which is handled by --truncate-on-start-mode")
+	truncStartMode  = flag.String("truncate-on-start-mode", "archive", "What to do with what the previous run left in --output with --truncate-on-start: archive it like --rotate-on-start, or discard it")
+	purgeOnFull     = flag.Bool("purge-on-full", false, "Remove the oldest archives regardless of --max-files while the disk is full")
+	minFree         = sizeVar("min-free", 0, "Remove the oldest archives regardless of --max-files while less than this `size` is free on the disk of --output, 0 to disable")
+	minFreeBlock    = flag.Bool("min-free-block", false, "Stop writing, and so reading the input, while less than --min-free is free with all archives removed")
+	stderrOutput    = flag.String("stderr-output", "", "Output file to rotate the stderr of the command given after -- into")
+	stderrPrefix    = flag.String("stderr-prefix", "", "Merge the stderr of the command given after -- into --output with this prefix on every line")
+	inputFIFO       = flag.String("input", "", "Comma separated named pipes to read lines from instead of stdin, reopened whenever their writer closes them, - for stdin")
+	followFile      = flag.String("follow", "", "Comma separated files to read the lines appended to instead of stdin, like tail -F")
+	listenTCP       = flag.String("listen-tcp", "", "Comma separated addresses to accept TCP connections on and read lines from instead of stdin, e.g. :5140")
+	listenUDP       = flag.String("listen-udp", "", "Comma separated addresses to receive UDP datagrams on instead of reading stdin, every datagram is one line, e.g. :5141")
+	listenUnix      = flag.String("listen-unix", "", "Comma separated paths of unix stream sockets to accept connections on and read lines from instead of stdin")
+	listenUnixgram  = flag.String("listen-unixgram", "", "Comma separated paths of unix datagram sockets to receive lines on instead of stdin, every datagram is one line")
+	unixMode        = flag.String("unix-mode", "", "Octal permissions of the --listen-unix and --listen-unixgram sockets, e.g. 0660, the umask applies if empty")
+	maxDatagram     = sizeVar("max-datagram-size", 65535, "Datagrams received on --listen-udp and --listen-unixgram are truncated at this `size`")
+	routeField      = flag.String("route-json-field", "", "Field of JSON or logfmt lines whose value selects the file of --route to write them to")
+	routeFiles      = flag.String("route", "", "Comma separated value=file mappings writing the lines whose --route-json-field has the value to the file instead of --output, e.g. error=errors.log")
+	jsonCompact     = flag.Bool("json-compact", false, "Parse every line as JSON and write it without insignificant whitespace")
+	jsonSortKeys    = flag.Bool("json-sort-keys", false, "Parse every line as JSON and write it compacted with the keys of objects in sorted order")
+	jsonInvalid     = flag.String("json-invalid", "", "Output file for the lines that are not valid JSON with --json-compact or --json-sort-keys, instead of --output")
+	stripANSI       = flag.Bool("strip-ansi", false, "Remove terminal escape sequences like colors from the lines")
+	controlChars    = flag.String("control-chars", "keep", "What to do with control characters like carriage returns and bells in the lines: keep, escape them like \\x07 or drop them")
+	numberLines     = flag.Bool("number-lines", false, "Prefix every line with an increasing number, continued after restarts from OUTPUT.lineno, so consumers can detect gaps and duplicates")
+	sandboxed       = flag.Bool("sandbox", false, "Confine the process with Landlock on Linux to the directories of the files it writes and the files it reads, so hostile input cannot use its permissions elsewhere")
+	sandboxAllow    = flag.String("sandbox-allow", "", "Comma separated files and directories --sandbox may also write to")
+	seccomp         = flag.Bool("seccomp", false, "Allow only the system calls needed for reading, writing and rotating once started, on Linux on amd64 and arm64, with --input-compression gzip or none")
+	instance        = flag.String("instance", "", "Name of this process among several on the host, put into the names of --output, --fallback-output, --stderr-output, --pidfile, --rotate-marker and --rotate-fifo, in place of {instance} or before their extension, and in front of every line")
+	queueSize       = flag.Int("queue-size", 0, "Number of lines to queue for writing, so reading goes on while the output is slow, 0 to write every line right away")
+	peerPrefix      = flag.Bool("peer-prefix", false, "Prefix the lines read from --listen-tcp and --listen-udp with the address of the peer")
 )
 
+// queuePolicies are the values of --compress-queue-policy.
+var queuePolicies = map[string]rotate.QueuePolicy{
+	"block":       rotate.QueueBlock,
+	"skip":        rotate.QueueSkip,
+	"drop-oldest": rotate.QueueDropOldest,
+}
+
+// retentionPolicies are the values of --retention-policy.
+var retentionPolicies = map[string]rotate.RetentionPolicy{
+	"and": rotate.RetainAll,
+	"or":  rotate.RetainAny,
+}
+
+// symlinkPolicies are the values of --output-symlink.
+var symlinkPolicies = map[string]rotate.SymlinkPolicy{
+	"follow":  rotate.SymlinkFollow,
+	"replace": rotate.SymlinkReplace,
+}
+
+// subcommands are run instead of reading lines if given as first argument.
+var subcommands = map[string]func(args []string) int{
+	"bench":    runBench,
+	"cat":      runCat,
+	"compress": runCompress,
+	"doctor":   runDoctor,
+	"grep":     runGrep,
+	"purge":    runPurge,
+	"repack":   runRepack,
+	"tail":     runTail,
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s\n\treads lines from stdin in writes them compressed with gzip\n\tinto 'output' rotating them as specified by flags\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\nUSAGE:\n\t%s [FLAGS]\n\t%s [FLAGS] -- COMMAND [ARGS...]\n\t\truns COMMAND and reads its stdout instead, exiting with its status\n", path.Base(os.Args[0]), path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s grep PATTERN [-output FILE]\n\t\tsearches --output and its archives\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s cat [-output FILE] [-since TIME] [-until TIME]\n\t\tprints --output and its archives oldest first\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s tail [-output FILE] [-n LINES] [-f]\n\t\tprints the last lines of --output, following it across rotations with -f\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s purge [FLAGS]\n\t\tremoves the archives of --output the retention flags do not keep\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s repack [FLAGS]\n\t\trewrites the archives of --output as FLAGS configure, oldest first\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s compress DIR [FLAGS]\n\t\tcompresses the archives in DIR not yet compressed as FLAGS configure\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s doctor [FLAGS]\n\t\tchecks the host for running with FLAGS and rotates a test file next to --output\n", path.Base(os.Args[0]))
+		fmt.Fprintf(os.Stderr, "\t%s bench [FLAGS]\n\t\tmeasures how fast lines can be written with FLAGS\n", path.Base(os.Args[0]))
 		fmt.Fprintf(os.Stderr, "\nFLAGS:\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nThe input flags --input, --follow and --listen-* can be combined. An address given as label=address prefixes its lines with the label.\n")
+		fmt.Fprintf(os.Stderr, "\nEvery flag can also be set with an environment variable, e.g. %s for -max-files.\n", envName("max-files"))
+	}
+	if err := applyEnvironment(flag.CommandLine); err != nil {
+		log.Fatalln("ERROR:", err)
 	}
 	flag.Parse()
+	var cfg *config
+	if *configFile != "" {
+		cfg = newConfig(flag.CommandLine, *configFile)
+		if err := cfg.load(nil); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if err := applyInstance(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if *checkOnly {
+		os.Exit(runCheck())
+	}
+	if *daemon {
+		daemonize()
+	}
+	sandbox()
+	if err := setupLogging(*logLevelName, *logFormat, *logFile); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if *onError != "continue" && *onError != "exit" {
+		log.Fatalln("ERROR: unknown --on-error policy", *onError)
+	}
+	if _, ok := queuePolicies[*compressPolicy]; !ok {
+		log.Fatalln("ERROR: unknown --compress-queue-policy", *compressPolicy)
+	}
+	if *compressWindow != "" {
+		if _, err := rotate.ParseWindow(*compressWindow); err != nil {
+			log.Fatalln("ERROR: invalid --compress-window:", err)
+		}
+	}
+	if _, ok := symlinkPolicies[*outputSymlink]; !ok {
+		log.Fatalln("ERROR: unknown --output-symlink policy", *outputSymlink)
+	}
+	if _, ok := retentionPolicies[*retentionMode]; !ok {
+		log.Fatalln("ERROR: unknown --retention-policy", *retentionMode)
+	}
+	if _, ok := controlMetrics[*controlChars]; !ok && *controlChars != "keep" {
+		log.Fatalln("ERROR: unknown --control-chars policy", *controlChars)
+	}
+	if *jsonInvalid != "" && !*jsonCompact && !*jsonSortKeys {
+		log.Fatalln("ERROR: --json-invalid requires --json-compact or --json-sort-keys")
+	}
+	if *encryptLive && *encryptRcpt == "" {
+		log.Fatalln("ERROR: --encrypt-live requires --encrypt-recipient")
+	}
+	if *encryptLive && *rotateCopyTrunc {
+		log.Fatalln("ERROR: --encrypt-live cannot be used with --rotate-copytruncate")
+	}
+	if *directIO && (*encryptLive || *rotateCopyTrunc) {
+		log.Fatalln("ERROR: --direct-io cannot be used with --encrypt-live or --rotate-copytruncate")
+	}
+	if err := checkSharedOutput(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if err := checkSeccomp(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if err := checkNoFile(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if err := checkArchiveNumbered(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if err := checkEncryptRecipients(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if _, ok := severities[*syslogMinLevel]; !ok && *syslogMinLevel != "" {
+		log.Fatalln("ERROR: unknown --syslog-min-level", *syslogMinLevel)
+	}
+	if *journaldPrio < 0 || *journaldPrio > 7 {
+		log.Fatalln("ERROR: --journald-priority must be between 0 and 7")
+	}
+	if err := checkInputCompression(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if *onEOF != "exit" && *onEOF != "wait" && *onEOF != "rotate" {
+		log.Fatalln("ERROR: unknown --on-eof policy", *onEOF)
+	}
+	if *truncStartMode != "archive" && *truncStartMode != "discard" {
+		log.Fatalln("ERROR: unknown --truncate-on-start-mode", *truncStartMode)
+	}
+	if *truncOnStart && *truncStartMode == "discard" && *rotateOnStart {
+		log.Fatalln("ERROR: --truncate-on-start-mode discard cannot be used with --rotate-on-start")
+	}
+	if *onEOF == "wait" && flag.NArg() > 0 {
+		log.Fatalln("ERROR: --on-eof wait cannot be used with a command")
+	}
+	var sources []source
+	if *copyTrunc {
+		if *inputFIFO != "" || *followFile != "" || *listenTCP != "" || *listenUDP != "" || *listenUnix != "" || *listenUnixgram != "" || flag.NArg() > 0 {
+			logFatal("--copytruncate cannot be used with inputs or a command")
+		}
+		if *copyInterval <= 0 {
+			logFatal("--copytruncate-interval must be positive")
+		}
+	} else {
+		var err error
+		sources, err = openInputs()
+		if err != nil {
+			logFatal(err)
+		}
+	}
+	if *noFile {
+		logDebug("not writing any file with --no-file")
+	} else if *nfsSafe {
+		logDebug("not locking output with --nfs-safe")
+	} else if err := lockOutput(*outputFile); err != nil {
+		logFatal(err)
+	}
+	startPprof()
 
-	var appender Appender
-	appender.lastFileChan = make(chan string, 100)
-	appender.openFile()
-	defer appender.closeFile()
-	go appender.listenForSignals()
-	go appender.manageFiles()
+	var p pipeline
+	p.metrics = rotate.NewMetrics()
+	p.config = cfg
+	if *instance != "" {
+		p.instancePrefix = []byte(*instance + " ")
+	}
+	if *numberLines {
+		var err error
+		if p.numberer, err = openLineNumberer(*outputFile + lineNumberSuffix); err != nil {
+			logFatal(err)
+		}
+	}
+	p.openAppender()
+	p.openRoutes()
+	if err := p.compileFlushRegexp(); err != nil {
+		logFatal(err)
+	}
+	if err := p.openSyslog(); err != nil {
+		logFatal(err)
+	}
+	p.openKafka()
+	p.openJournal()
+	p.openGelf()
+	p.openForward()
+	p.openFluentd()
+	p.openHTTP()
+	p.startStatsd()
+	p.startStats()
+	p.startHealth()
+	p.startWatchdog()
+	p.startQueue()
+	p.startHeartbeat()
+	p.startNotify()
+	p.registerCounters()
+	p.stdin = os.Stdin
+	var cmd *child
+	if flag.NArg() > 0 {
+		var err error
+		cmd, err = startChild(flag.Args(), p.childStderr())
+		if err != nil {
+			logFatal(err)
+		}
+		p.stdin = cmd.stdout
+	} else {
+		go p.listenForSignals()
+	}
+	go p.listenForReload()
+	go p.watchErrors()
+	if err := writePidfile(); err != nil {
+		logFatal(err)
+	}
+	sdNotify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()))
+	if *seccomp {
+		if err := installSeccomp(); err != nil {
+			logFatal(err)
+		}
+		logDebug("installed the seccomp filter")
+	}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() && !appender.closed {
-		line := scanner.Text()
-		appender.Append(line)
+	if *copyTrunc {
+		p.copyTruncate()
+	} else {
+		p.readSources(sources)
 	}
 
-	appender.wg.Wait()
+	status := 0
+	if cmd != nil {
+		status = cmd.wait()
+		p.closeStderr()
+	}
+	p.shutdown(status, true)
 }
 
-// Appender is the type responsible for appending and rotating files
-type Appender struct {
-	file         *os.File
-	filePath     string
-	writer       *bufio.Writer
-	bytesWritten int
-	closed       bool
-	syslog       *syslog.Writer
-	regexp       *regexp.Regexp
-
-	wg           sync.WaitGroup
-	lastFileChan chan string
+// pipeline forwards the lines read from the input to the syslog server,
+// Kafka, journald and the other forwarding outputs and appends them to the
+// rotating output file.
+type pipeline struct {
+	appender      *rotate.Appender
+	closed        atomic.Bool
+	syslog        *syslogSender
+	regexp        *regexp.Regexp
+	kafka         *kafkaProducer
+	kafkaRegexp   *regexp.Regexp
+	journal       *journalWriter
+	gelf          *gelfSender
+	forward       *tcpForwarder
+	fluentd       *tcpForwarder
+	shipper       *httpShipper
+	gelfRegexp    *regexp.Regexp
+	journalRegexp *regexp.Regexp
+	flushRegexp   *regexp.Regexp
+	metrics       *rotate.Metrics
+	stdin         io.Reader
+	stderr        *lineWriter
+	stderrFile    *rotate.Appender
+	routes        map[string]*rotate.Appender
+	invalidJSON   *rotate.Appender
+	health        health
+	queue         *lineQueue
+	config        *config
+	lastErr       string
+	// failStatus is the status writeFailed exits with, taking precedence
+	// over that of the shutdown it races with.
+	failStatus int
+
+	// instancePrefix is put in front of every line, prefixed is the
+	// buffer for doing so.
+	instancePrefix []byte
+	prefixed       []byte
+	// numberer numbers the lines with --number-lines.
+	numberer *lineNumberer
+	// transformed, escaped and normalized are the buffers for the lines changed by
+	// transform.
+	transformed []byte
+	escaped     []byte
+	normalized  bytes.Buffer
+
+	mu       sync.Mutex
+	exitOnce sync.Once
 }
 
-func (s *Appender) listenForSignals() {
+func (s *pipeline) listenForSignals() {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
 	// Block until a signal is received.
 	<-c
-	s.closed = true
-	s.closeFile()
-	s.wg.Wait()
-	os.Exit(0)
+	s.shutdown(0, true)
 }
 
-func (s *Appender) openFile() {
-	f, err := os.OpenFile(*outputFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalln("ERROR: cannot open file:", err)
+func (s *pipeline) listenForReload() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	for range c {
+		s.reload()
+	}
+}
+
+// reload applies the config file again without interrupting the output.
+func (s *pipeline) reload() {
+	if s.config == nil {
+		logWarn("received SIGHUP without --config, ignoring it")
+		return
 	}
 
-	s.file = f
-	s.filePath = *outputFile
-	s.writer = bufio.NewWriter(f)
-	st, err := s.file.Stat()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.config.load(reloadableFlags); err != nil {
+		logError("cannot reload config:", err)
+		return
+	}
+	if err := setLogLevel(*logLevelName); err != nil {
+		logError("cannot reload config:", err)
+	}
+	if s.appender != nil {
+		s.appender.SetMaxSize(*maxFileSize)
+		s.appender.SetMaxFiles(retainedFiles())
+	}
+	for _, a := range s.routeAppenders() {
+		a.SetMaxSize(*maxFileSize)
+		a.SetMaxFiles(retainedFiles())
+	}
+	if err := s.openSyslog(); err != nil {
+		logError("cannot reload config:", err)
+	}
+	if err := s.compileKafkaRegexp(); err != nil {
+		logError("cannot reload config:", err)
+	}
+	if s.journal != nil {
+		if err := s.compileJournalRegexp(); err != nil {
+			logError("cannot reload config:", err)
+		}
+	}
+	if s.gelf != nil {
+		if err := s.compileGelfRegexp(); err != nil {
+			logError("cannot reload config:", err)
+		}
+	}
+	if err := s.compileFlushRegexp(); err != nil {
+		logError("cannot reload config:", err)
+	}
+	s.registerCounters()
+	logInfo("reloaded config from", s.config.fileName)
+}
+
+func (s *pipeline) openAppender() {
+	if *noFile {
+		return
+	}
+
+	opts := appenderOptions(*outputFile)
+	opts.Metrics = s.metrics
+	// Only rotations of the output are announced and switch to the
+	// fallback, not those of --route, --json-invalid and --stderr-output.
+	opts.FallbackPath = *fallbackOutput
+	opts.FallbackRetry = *fallbackRetry
+	opts.RotationMarker = *rotateMarker
+	opts.RotationFIFO = *rotateFIFO
+
+	var err error
+	s.appender, err = rotate.New(opts)
 	if err != nil {
-		log.Fatalln("ERROR", err)
+		logFatal(err)
 	}
-	s.bytesWritten = int(st.Size())
+}
 
+// closeAppender closes the output file, unless there is none with
+// --no-file.
+func (s *pipeline) closeAppender() {
+	if s.appender != nil {
+		s.appender.Close()
+	}
+}
+
+// retainedFiles returns the number of archives to keep by --max-files and
+// --no-cleanup, 0 for all.
+func retainedFiles() int {
+	if *noCleanup || *maxFiles < 0 {
+		return 0
+	}
+	return *maxFiles
+}
+
+// checkArchiveNumbered validates the flags of --archive-numbered, whose
+// archive names neither hold the time of rotation nor stay the same.
+func checkArchiveNumbered() error {
+	if !*archiveNumbers {
+		return nil
+	}
+	for _, conflict := range []struct {
+		name string
+		set  bool
+	}{
+		{"--archive-sequence", *archiveSeq},
+		{"--max-age", *maxAge > 0},
+		{"--max-files-per-day", *maxPerDay > 0},
+		{"--checksum", *checksum},
+		{"--manifest", *manifest},
+		{"--metadata", *metadata},
+		{"--shared-output", *sharedOutput},
+	} {
+		if conflict.set {
+			return fmt.Errorf("--archive-numbered cannot be used with %s", conflict.name)
+		}
+	}
+	return nil
+}
+
+// checkEncryptRecipients makes sure --encrypt-recipient lists either age
+// public keys or GPG key ids, as a file is encrypted by one of the two.
+func checkEncryptRecipients() error {
+	if *encryptRcpt == "" {
+		return nil
+	}
+	recipients := strings.Split(*encryptRcpt, ",")
+	age := strings.HasPrefix(recipients[0], "age1")
+	for _, r := range recipients[1:] {
+		if strings.HasPrefix(r, "age1") != age {
+			return fmt.Errorf("--encrypt-recipient cannot mix age public keys and GPG key ids, got %q and %q", recipients[0], r)
+		}
+	}
+	return nil
+}
+
+// appenderOptions returns the options for rotating the file at path as set
+// by the flags.
+func appenderOptions(path string) rotate.Options {
+	opts := rotate.Options{
+		Path:               path,
+		MaxSize:            *maxFileSize,
+		MaxFiles:           retainedFiles(),
+		Compress:           *compressOld,
+		CompressBandwidth:  int64(*compressRate),
+		LowPriority:        *compressNice,
+		QueueSize:          *compressQueue,
+		QueuePolicy:        queuePolicies[*compressPolicy],
+		MaxAge:             *maxAge,
+		RetentionPolicy:    retentionPolicies[*retentionMode],
+		MaxFilesPerDay:     *maxPerDay,
+		MaxTotalSize:       int64(*maxTotalSize),
+		KeepUncompressed:   *keepPlain,
+		Checksum:           *checksum,
+		VerifyChecksum:     *verifyChecksum,
+		RetentionDryRun:    *retentionDry,
+		Manifest:           *manifest,
+		Metadata:           *metadata,
+		Logger:             rotateLogger{},
+		WriteRetries:       *writeRetries,
+		RetryDelay:         *retryDelay,
+		PurgeOnFull:        *purgeOnFull,
+		MinFreeSpace:       int64(*minFree),
+		BlockOnLowSpace:    *minFreeBlock,
+		StatInterval:       *statInterval,
+		RotateCopyTruncate: *rotateCopyTrunc,
+		EncryptLive:        *encryptLive,
+		Preallocate:        *preallocate,
+		DirectIO:           *directIO,
+		SyncWrites:         *syncWrites,
+		Symlinks:           symlinkPolicies[*outputSymlink],
+		NFSSafe:            *nfsSafe,
+		UTC:                *archiveUTC,
+		Sequence:           *archiveSeq,
+		Numbered:           *archiveNumbers,
+		RotateOnStart:      *rotateOnStart || *truncOnStart && *truncStartMode == "archive",
+		TruncateOnStart:    *truncOnStart && *truncStartMode == "discard",
+		Shared:             *sharedOutput,
+	}
+	if *compressWindow != "" {
+		opts.CompressWindow, _ = rotate.ParseWindow(*compressWindow)
+	}
+	if *noCleanup {
+		opts.MaxAge, opts.MaxFilesPerDay, opts.MaxTotalSize = 0, 0, 0
+	}
+	if *delayCompress && opts.KeepUncompressed < 1 {
+		opts.KeepUncompressed = 1
+	}
+	if *encryptRcpt != "" {
+		opts.EncryptRecipients = strings.Split(*encryptRcpt, ",")
+	}
+	return opts
+}
+
+// openSyslog connects to --syslog-target, replacing the current connection.
+// The current connection is kept if the new one cannot be set up.
+func (s *pipeline) openSyslog() error {
+	var w *syslog.Writer
+	var re *regexp.Regexp
+	var err error
 	if *syslogTarget != "" {
-		s.syslog, err = syslog.Dial("udp", *syslogTarget, syslog.Priority(*syslogPriority), *syslogTag)
+		w, err = syslog.Dial("udp", *syslogTarget, syslog.Priority(*syslogPriority), *syslogTag)
 		if err != nil {
-			log.Fatalln("ERROR: cannot connect to syslog server:", err)
+			return fmt.Errorf("cannot connect to syslog server: %v", err)
 		}
 
 		if *syslogRegexp != "" {
-			s.regexp, err = regexp.Compile(*syslogRegexp)
+			re, err = regexp.Compile(*syslogRegexp)
 			if err != nil {
-				log.Fatalln("ERROR: cannot compile syslog regexp:", err)
+				w.Close()
+				return fmt.Errorf("cannot compile syslog regexp: %v", err)
 			}
 		}
 	}
-}
 
-func (s *Appender) closeFile() {
-	s.writer.Flush()
-	s.file.Close()
+	if s.syslog != nil {
+		s.syslog.Close()
+		s.syslog = nil
+	}
+	if w != nil {
+		var failed func(error)
+		if *strict {
+			failed = func(err error) {
+				// Closing the pipeline waits for the sender calling this.
+				go s.exitAfterError(failures.add("syslog_failed", *syslogTarget, err))
+			}
+		}
+		s.syslog = newSyslogSender(w, &s.health, s.metrics, failed)
+	}
+	s.regexp = re
+	return nil
 }
 
-func (s *Appender) rotateFile() {
-	s.closeFile()
+func (s *pipeline) openKafka() {
+	if *kafkaBrokers == "" {
+		return
+	}
+	if *kafkaTopic == "" {
+		logFatal("--kafka-topic is required with --kafka-brokers")
+	}
 
-	archiveName := s.archiveFileName()
-	os.Rename(s.filePath, archiveName)
-	s.wg.Add(1)
-	s.lastFileChan <- archiveName
+	var err error
+	s.kafka, err = newKafkaProducer(strings.Split(*kafkaBrokers, ","), *kafkaTopic, s.metrics)
+	if err != nil {
+		logFatal("cannot connect to kafka:", err)
+	}
 
-	s.openFile()
+	if err := s.compileKafkaRegexp(); err != nil {
+		logFatal(err)
+	}
 }
 
-func (s *Appender) manageFiles() {
-	for lastFile := range s.lastFileChan {
-		if *compressOld {
-			s.compressFile(lastFile)
-		}
-		s.removeOldFiles()
-		s.wg.Done()
+func (s *pipeline) compileKafkaRegexp() error {
+	if *kafkaRegexp == "" {
+		s.kafkaRegexp = nil
+		return nil
+	}
+
+	re, err := regexp.Compile(*kafkaRegexp)
+	if err != nil {
+		return fmt.Errorf("cannot compile kafka regexp: %v", err)
+	}
+	s.kafkaRegexp = re
+	return nil
+}
+
+// closeForwarders sends what is still queued for syslog, Kafka and the
+// other forwarding outputs.
+func (s *pipeline) closeForwarders() {
+	if s.syslog != nil {
+		s.syslog.Close()
+	}
+	if s.kafka != nil {
+		s.kafka.Close()
+	}
+	if s.gelf != nil {
+		s.gelf.Close()
+	}
+	if s.forward != nil {
+		s.forward.Close()
+	}
+	if s.fluentd != nil {
+		s.fluentd.Close()
+	}
+	if s.shipper != nil {
+		s.shipper.Close()
 	}
 }
 
-func (s *Appender) removeOldFiles() {
-	infos, err := ioutil.ReadDir(path.Dir(s.filePath))
+func (s *pipeline) startStatsd() {
+	if *statsdTarget == "" {
+		return
+	}
+
+	reporter, err := newStatsdReporter(*statsdTarget, *statsdPrefix, *statsdTags, s.metrics)
 	if err != nil {
-		log.Fatalln("ERROR", err)
+		logFatal("cannot connect to statsd:", err)
 	}
+	go reporter.run(*statsdInterval)
+}
 
-	archives := []string{}
-	baseName := path.Base(s.filePath)
-	dir := path.Dir(s.filePath)
-	for _, info := range infos {
-		name := info.Name()
-		if strings.HasPrefix(name, baseName+"_2") {
-			archives = append(archives, name)
-		}
+func (s *pipeline) startStats() {
+	if *statsInterval <= 0 {
+		return
 	}
 
-	sort.Strings(archives)
-	for index := 0; index < len(archives)-*maxFiles; index++ {
-		fileName := archives[index]
-		err := os.Remove(path.Join(dir, fileName))
+	var out io.Writer = os.Stderr
+	if *statsFile != "" {
+		f, err := os.OpenFile(*statsFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 		if err != nil {
-			log.Fatalln("ERROR", err)
+			logFatal("cannot open stats file:", err)
 		}
+		out = f
 	}
+	go newStatsReporter(out, s.metrics).run(*statsInterval)
 }
 
-func (s *Appender) compressFile(fileName string) {
-	inFile, err := os.Open(fileName)
-	if err != nil {
-		log.Fatalln("ERROR: cannot open file:", err)
+// Append forwards line to the syslog server and Kafka if it matches their
+// regexps and appends it to the output file.
+func (s *pipeline) Append(line string) {
+	s.AppendBytes([]byte(line))
+}
+
+// AppendBytes is Append without allocating for the line, which is not
+// retained, so readers can reuse their buffers.
+func (s *pipeline) AppendBytes(line []byte) {
+	s.health.received()
+	if s.queue != nil {
+		s.enqueue(line)
+		return
 	}
+	s.write(line)
+}
 
-	outFile, err := os.OpenFile(fileName+".gz", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalln("ERROR: cannot open file:", err)
+// write forwards and appends a line right away.
+func (s *pipeline) write(line []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, valid := s.transform(line)
+	a := s.route(line)
+	if !valid && s.invalidJSON != nil {
+		a = s.invalidJSON
+	}
+	line = s.numberLine(s.prefixInstance(line))
+	severity, leveled := lineSeverity(line)
+	if s.syslog != nil {
+		send := s.regexp == nil || s.regexp.Match(line)
+		if leveled && *syslogMinLevel != "" {
+			send = severity <= severities[*syslogMinLevel]
+		}
+		if send && leveled {
+			s.syslog.WriteSeverity(line, severity)
+			s.metrics.Add("syslog.lines", 1)
+		} else if send {
+			s.syslog.Write(line)
+			s.metrics.Add("syslog.lines", 1)
+		} else {
+			s.metrics.Add("syslog.filtered", 1)
+		}
 	}
 
-	w := gzip.NewWriter(outFile)
+	if s.kafka != nil {
+		if s.kafkaRegexp == nil || s.kafkaRegexp.Match(line) {
+			s.kafka.Write(line)
+			s.metrics.Add("kafka.lines", 1)
+		} else {
+			s.metrics.Add("kafka.filtered", 1)
+		}
+	}
+
+	if s.gelf != nil {
+		if s.gelfRegexp == nil || s.gelfRegexp.Match(line) {
+			level := 6
+			if leveled {
+				level = severity
+			}
+			s.gelf.Write(line, level)
+		} else {
+			s.metrics.Add("gelf.filtered", 1)
+		}
+	}
 
-	io.Copy(w, inFile)
+	if s.forward != nil {
+		s.forward.Write(line)
+	}
+
+	if s.fluentd != nil {
+		s.fluentd.Write(line)
+	}
+
+	if s.shipper != nil {
+		s.shipper.Write(line)
+	}
 
-	w.Close()
-	inFile.Close()
-	outFile.Close()
+	if s.journal != nil {
+		priority := *journaldPrio
+		if leveled {
+			priority = severity
+		}
+		s.sendJournal(line, priority)
+	}
 
-	os.Remove(fileName)
+	if a == nil {
+		// With --no-file the line is done once it is forwarded.
+		s.health.wrote(nil)
+		return
+	}
+	err := a.AppendBytes(line)
+	if err == nil && s.flushRegexp != nil && s.flushRegexp.Match(line) {
+		s.metrics.Add("flush.matched", 1)
+		err = a.Sync()
+	}
+	s.health.wrote(err)
+	if err != nil {
+		s.writeFailed(a, err)
+	}
 }
 
-func (s *Appender) archiveFileName() string {
-	ts := time.Now().Format("2006-01-02T15.04.05.000000000Z0700")
-	return s.filePath + "_" + ts
+func (s *pipeline) compileFlushRegexp() error {
+	if *flushRegexp == "" {
+		s.flushRegexp = nil
+		return nil
+	}
+
+	re, err := regexp.Compile(*flushRegexp)
+	if err != nil {
+		return fmt.Errorf("cannot compile flush regexp: %v", err)
+	}
+	s.flushRegexp = re
+	return nil
 }
 
-// Append inserts line at the end of file and asks file to be rotated if it is too big.
-func (s *Appender) Append(line string) {
-	if s.bytesWritten >= *maxFileSize {
-		s.rotateFile()
+// writeFailed logs err unless it is the same as the previous one, so a full
+// disk does not log every line, and exits with --on-error exit. Lost lines
+// make the process exit with exitWriteFailed either way. It needs s.mu, so
+// it stops reading and leaves the exit to another goroutine.
+func (s *pipeline) writeFailed(a *rotate.Appender, err error) {
+	if err.Error() != s.lastErr {
+		logEvent(levelError, "write_failed", logFields{"file": a.Path(), "error": err.Error(), "disk_full": rotate.IsNoSpace(err)},
+			"cannot write line:", err)
+		s.lastErr = err.Error()
+	}
+	status := 0
+	if *strict {
+		status = failures.add("write_failed", a.Path(), err)
+	} else if *onError == "exit" {
+		status = exitWriteFailed
 	}
+	if status != 0 && s.failStatus == 0 {
+		s.closed.Store(true)
+		s.failStatus = status
+		go s.exitAfterError(status)
+	}
+}
 
-	if s.syslog != nil {
-		byteline := []byte(line)
-		if s.regexp == nil || s.regexp.Match(byteline) {
-			s.syslog.Write(byteline)
+// watchErrors applies --on-error to the failures of background processing,
+// which are already logged by the Appender.
+func (s *pipeline) watchErrors() {
+	if s.appender == nil {
+		return
+	}
+	for err := range s.appender.Errors() {
+		if *strict {
+			var failure *rotate.Error
+			if errors.As(err, &failure) {
+				s.exitAfterError(failures.add(failure.Event, failure.File, failure.Err))
+			}
+		}
+		if *onError == "exit" {
+			s.exitAfterError(exitError)
 		}
 	}
+}
 
-	n, _ := s.writer.WriteString(line)
-	s.writer.WriteByte('\n')
-	s.writer.Flush()
+// exitAfterError stops reading, closes the output and exits with status,
+// dropping the lines still queued.
+func (s *pipeline) exitAfterError(status int) {
+	s.shutdown(status, false)
+}
 
-	s.bytesWritten += n + 1
+// shutdown stops reading, writes the lines still queued if flush is set,
+// closes the outputs and exits with status, or exitWriteFailed if lines were
+// lost. Only the first call does so, the others block until the exit.
+func (s *pipeline) shutdown(status int, flush bool) {
+	s.exitOnce.Do(func() {
+		sdNotify("STOPPING=1")
+		s.mu.Lock()
+		s.closed.Store(true)
+		s.mu.Unlock()
+		if flush {
+			// The queue writes its lines with s.mu.
+			s.flushQueue()
+		}
+
+		// Held until the exit, so that no line is written to a closed output.
+		s.mu.Lock()
+		s.closeAppender()
+		s.closeRoutes()
+		s.closeForwarders()
+		s.closeNumbering()
+		if s.failStatus != 0 {
+			status = s.failStatus
+		} else if status == 0 && s.lastErr != "" {
+			status = exitWriteFailed
+		}
+		exit(status)
+	})
 }
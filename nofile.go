@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// checkNoFile validates the flags of --no-file, which forwards the lines
+// without writing any file, so needs somewhere to forward them to and
+// cannot be combined with the features writing files next to --output.
+func checkNoFile() error {
+	if !*noFile {
+		return nil
+	}
+	if *syslogTarget == "" && *kafkaBrokers == "" && !*journald && *gelfTarget == "" &&
+		*forwardTCP == "" && *fluentdTarget == "" && *httpTarget == "" {
+		return errors.New("--no-file requires a forwarding output like --syslog-target, --kafka-brokers or --http-target")
+	}
+	for _, conflict := range []struct {
+		name string
+		set  bool
+	}{
+		{"--fallback-output", *fallbackOutput != ""},
+		{"--stderr-output", *stderrOutput != ""},
+		{"--route", *routeFiles != ""},
+		{"--json-invalid", *jsonInvalid != ""},
+		{"--copytruncate", *copyTrunc},
+		{"--shared-output", *sharedOutput},
+		{"--number-lines", *numberLines},
+		{"--heartbeat", *heartbeat > 0},
+		{"--rotate-marker", *rotateMarker != ""},
+		{"--rotate-fifo", *rotateFIFO != ""},
+		{"--on-eof rotate", *onEOF == "rotate"},
+	} {
+		if conflict.set {
+			return fmt.Errorf("--no-file cannot be used with %s", conflict.name)
+		}
+	}
+	return nil
+}
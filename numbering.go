@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// lineNumberSuffix is appended to --output for the file keeping the
+	// next number of --number-lines across restarts.
+	lineNumberSuffix = ".lineno"
+	// lineNumberReserve is how many numbers are reserved in the file at
+	// once, so it is not written for every line. After a crash numbering
+	// continues after the reserved ones, leaving a gap but never using a
+	// number twice.
+	lineNumberReserve = 10000
+)
+
+// lineNumberer prefixes lines with increasing numbers for --number-lines.
+type lineNumberer struct {
+	path     string
+	mu       sync.Mutex
+	next     uint64
+	reserved uint64
+	buf      []byte
+}
+
+// openLineNumberer continues after the numbers used or reserved by the
+// previous process.
+func openLineNumberer(path string) (*lineNumberer, error) {
+	n := &lineNumberer{path: path, next: 1}
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read line number: %v", err)
+	}
+	if err == nil {
+		if n.next, err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64); err != nil {
+			return nil, fmt.Errorf("cannot read line number from %s: %v", path, err)
+		}
+	}
+	n.reserved = n.next
+	return n, nil
+}
+
+// number returns line prefixed with its number, in a buffer reused for the
+// next line.
+func (n *lineNumberer) number(line []byte) []byte {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.next >= n.reserved {
+		n.reserved = n.next + lineNumberReserve
+		if err := n.save(n.reserved); err != nil {
+			logError("cannot save line number:", err)
+		}
+	}
+	n.buf = strconv.AppendUint(n.buf[:0], n.next, 10)
+	n.buf = append(append(n.buf, ' '), line...)
+	n.next++
+	return n.buf
+}
+
+// close saves the next number, so the next process continues without a
+// gap after a clean exit.
+func (n *lineNumberer) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.reserved = n.next
+	if err := n.save(n.next); err != nil {
+		logError("cannot save line number:", err)
+	}
+}
+
+// save replaces the file with next, synced so it survives a crash of the
+// machine.
+func (n *lineNumberer) save(next uint64) error {
+	tmpName := n.path + ".tmp"
+	f, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatUint(next, 10) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, n.path)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// numberLine returns line prefixed with its number with --number-lines.
+func (s *pipeline) numberLine(line []byte) []byte {
+	if s.numberer == nil {
+		return line
+	}
+	return s.numberer.number(line)
+}
+
+// closeNumbering saves the next number of --number-lines.
+func (s *pipeline) closeNumbering() {
+	if s.numberer != nil {
+		s.numberer.close()
+	}
+}
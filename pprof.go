@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+	_ "net/http/pprof" // registers the /debug/pprof handlers
+)
+
+// startPprof serves the profiling endpoints of net/http/pprof on their own
+// listener, so they are never exposed together with /healthz.
+func startPprof() {
+	if *pprofListen == "" {
+		return
+	}
+
+	go func() {
+		logFatal("pprof endpoint:", http.ListenAndServe(*pprofListen, nil))
+	}()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// runPurge applies the retention flags to the archives of --output and
+// --fallback-output once without reading any lines, for running them from
+// cron against directories no process rotates anymore.
+func runPurge(args []string) int {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate purge [FLAGS]\n\t\tremoves the archives of --output the retention flags do not keep\n")
+		fmt.Fprintf(os.Stderr, "\tOnly --max-files, --max-age, --max-files-per-day, --max-total-size and the flags about them apply.\n\nFLAGS:\n")
+		flag.PrintDefaults()
+	}
+	if args := parseArchiveCommand(args); len(args) != 0 {
+		flag.Usage()
+		return 2
+	}
+	if _, ok := retentionPolicies[*retentionMode]; !ok {
+		log.Fatalln("ERROR: unknown --retention-policy", *retentionMode)
+	}
+
+	opts := appenderOptions(*outputFile)
+	opts.FallbackPath = *fallbackOutput
+	// The failures are logged by the rotate logger already.
+	if err := rotate.Purge(opts); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// parseArchiveCommand parses the global flags, along with --config and
+// --instance, for a subcommand working on existing archives like purge, and
+// returns its arguments.
+func parseArchiveCommand(args []string) []string {
+	args = parseSubcommand(flag.CommandLine, args)
+	if *configFile != "" {
+		if err := newConfig(flag.CommandLine, *configFile).load(nil); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if err := applyInstance(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if err := setupLogging(*logLevelName, *logFormat, *logFile); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if err := checkArchiveNumbered(); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	return args
+}
@@ -0,0 +1,79 @@
+package main
+
+import "sync"
+
+// lineQueue passes the lines read to a goroutine writing them, so reading
+// goes on while the output is slow, e.g. during rotation.
+type lineQueue struct {
+	lines chan []byte
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// startQueue writes the lines from a queue of --queue-size lines, if it is
+// not 0.
+func (s *pipeline) startQueue() {
+	if *queueSize <= 0 {
+		return
+	}
+	s.queue = &lineQueue{
+		lines: make(chan []byte, *queueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.writeQueue()
+}
+
+// enqueue queues a copy of line, waiting for room in the queue unless it is
+// stopped.
+func (s *pipeline) enqueue(line []byte) {
+	line = append([]byte(nil), line...)
+	select {
+	case s.queue.lines <- line:
+		return
+	default:
+	}
+	s.metrics.Add("queue.full", 1)
+	select {
+	case s.queue.lines <- line:
+	case <-s.queue.stop:
+	}
+}
+
+func (s *pipeline) writeQueue() {
+	defer close(s.queue.done)
+	for {
+		select {
+		case line := <-s.queue.lines:
+			s.write(line)
+		case <-s.queue.stop:
+			for {
+				select {
+				case line := <-s.queue.lines:
+					s.write(line)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushQueue writes the lines still queued and stops the queue. Lines
+// appended afterwards are dropped.
+func (s *pipeline) flushQueue() {
+	if s.queue == nil {
+		return
+	}
+	s.queue.once.Do(func() { close(s.queue.stop) })
+	<-s.queue.done
+}
+
+// queueDepth returns the number of lines waiting in the queue.
+func (s *pipeline) queueDepth() int {
+	if s.queue == nil {
+		return 0
+	}
+	return len(s.queue.lines)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// runRepack rewrites the archives of --output with the flags, e.g. another
+// --max-size, --gzip or naming, for migrating existing archives along with
+// the configuration. It takes the lock of --output, so no instance may be
+// running on it.
+func runRepack(args []string) int {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate repack [FLAGS]\n\t\trewrites the archives of --output as FLAGS configure, oldest first\n")
+		fmt.Fprintf(os.Stderr, "\tThe retention flags do not apply, run purge afterwards for them.\n\nFLAGS:\n")
+		flag.PrintDefaults()
+	}
+	if args := parseArchiveCommand(args); len(args) != 0 {
+		flag.Usage()
+		return 2
+	}
+	if *noFile || *sharedOutput {
+		log.Fatalln("ERROR: repack cannot be used with --no-file or --shared-output")
+	}
+	if !*nfsSafe {
+		if err := lockOutput(*outputFile); err != nil {
+			log.Println("ERROR:", err)
+			return 1
+		}
+	}
+
+	opts := appenderOptions(*outputFile)
+	opts.Metrics = rotate.NewMetrics()
+	if err := rotate.Repack(opts); err != nil {
+		log.Println("ERROR:", err)
+		return 1
+	}
+	counters, _ := opts.Metrics.Snapshot()
+	logInfo("repacked the archives of", *outputFile, "into", counters["rotations"], "archives")
+	return 0
+}
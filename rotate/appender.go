@@ -0,0 +1,818 @@
+// Package rotate appends lines to a file and rotates it once it reaches a
+// maximum size. Rotated files are renamed to an archive with a timestamp
+// suffix, optionally compressed, encrypted and checksummed in the
+// background, and the oldest archives are removed.
+package rotate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Defaults for the zero values of Options.
+const (
+	DefaultMaxSize   = 10 * 1024 * 1024
+	DefaultQueueSize = 100
+)
+
+// Options configures an Appender. Only Path is required.
+type Options struct {
+	// Path of the file to append to. Archives are created next to it.
+	Path string
+	// Symlinks decides what happens if Path or FallbackPath is a symbolic
+	// link, SymlinkFollow by default.
+	Symlinks SymlinkPolicy
+	// MaxSize is the size in bytes at which the file is rotated.
+	MaxSize int
+	// MaxFiles is the number of archives to keep, all of them if 0 or less.
+	MaxFiles int
+	// MaxAge removes the archives rotated longer ago according to their
+	// names, after every rotation and at startup. They are kept regardless
+	// of their age if zero.
+	MaxAge time.Duration
+	// RetentionPolicy combines MaxFiles and MaxAge if both are set,
+	// RetainAll by default.
+	RetentionPolicy RetentionPolicy
+	// MaxFilesPerDay removes the oldest archives of a day, by the dates in
+	// their names, beyond this many, before MaxFiles counts the rest. No
+	// day is limited if zero.
+	MaxFilesPerDay int
+	// MaxTotalSize removes the oldest archives while they take more than
+	// this many bytes on disk together, after the other limits removed
+	// theirs. Their size is not limited if zero.
+	MaxTotalSize int64
+	// Compress archives with gzip.
+	Compress bool
+	// CompressBandwidth limits compression to reading this many bytes per
+	// second, it is not limited if zero.
+	CompressBandwidth int64
+	// CompressWindow limits compressing, encrypting and checksumming
+	// archives to a daily time range. Archives rotated outside of it stay
+	// as they are until it opens, also across restarts.
+	CompressWindow Window
+	// LowPriority processes and removes archives in a thread with the
+	// lowest CPU priority and the idle I/O scheduling class, on Linux only.
+	LowPriority bool
+	// KeepUncompressed is the number of newest archives to leave as they
+	// are with Compress. They are compressed, encrypted and checksummed
+	// once newer ones replace them, so 1 is logrotate's delaycompress.
+	KeepUncompressed int
+	// EncryptRecipients are either age public keys or GPG key ids to
+	// encrypt the archives for after compression. The age or gpg binary is
+	// used.
+	EncryptRecipients []string
+	// EncryptLive encrypts the file itself for EncryptRecipients while it
+	// is written, gzipped first with Compress, so no plaintext reaches the
+	// disk. Its archives are complete as they are rotated. Lines are only
+	// written once the encrypting process has a chunk or the file is
+	// rotated or closed, and a crash loses its last chunk. It cannot be
+	// combined with RotateCopyTruncate.
+	EncryptLive bool
+	// Checksum writes a .sha256 file next to each archive.
+	Checksum bool
+	// VerifyChecksum keeps archives not matching their checksum file
+	// instead of removing them.
+	VerifyChecksum bool
+	// Metadata writes a .meta.json file next to each archive with its time
+	// range, host, line count and size.
+	Metadata bool
+	// Manifest maintains Path + ".manifest.json" listing the archives with
+	// their time ranges, line counts, sizes and checksums.
+	Manifest bool
+	// RetentionDryRun logs the archives MaxFiles would remove instead of
+	// removing them. They are also checked right away.
+	RetentionDryRun bool
+	// QueueSize is how many archives may wait for compression and removal of
+	// old archives before rotation blocks.
+	QueueSize int
+	// QueuePolicy decides what happens to archives rotated while the queue
+	// is full, QueueBlock by default.
+	QueuePolicy QueuePolicy
+	// Metrics records counters and timings, a new one is used if nil.
+	Metrics *Metrics
+	// Logger receives diagnostics, nothing is logged if nil.
+	Logger Logger
+	// Clock returns the time used to name archives, time.Now if nil.
+	Clock func() time.Time
+	// UTC names archives with the time in UTC, ending in Z, instead of the
+	// local time with its offset.
+	UTC bool
+	// WriteRetries is how often a failed write is retried before Append
+	// gives up on the line.
+	WriteRetries int
+	// RetryDelay is the delay before the first retry, doubled for every
+	// further one. DefaultRetryDelay is used if zero.
+	RetryDelay time.Duration
+	// PurgeOnFull removes the oldest archive before each retry if the disk
+	// is full, regardless of MaxFiles.
+	PurgeOnFull bool
+	// MinFreeSpace removes the oldest archives, regardless of MaxFiles,
+	// while less than this many bytes are free on the filesystem of the
+	// file. It is checked at most once a second on writes.
+	MinFreeSpace int64
+	// BlockOnLowSpace makes writes wait while less than MinFreeSpace bytes
+	// are free with all archives removed, so the input backs up instead of
+	// the disk filling up.
+	BlockOnLowSpace bool
+	// FallbackPath is written to, and rotated like Path, while Path cannot
+	// be written to. Marker lines in both files note the gap.
+	FallbackPath string
+	// FallbackRetry is how often to check whether Path can be written to
+	// again, DefaultFallbackRetry if zero.
+	FallbackRetry time.Duration
+	// StatInterval is how often to check on writes whether the file was
+	// deleted, replaced, truncated or appended to by someone else, taking
+	// over its size for rotation. Zero disables it.
+	StatInterval time.Duration
+	// NFSSafe rotates by copying the file to the archive, syncing the copy
+	// and removing the file instead of renaming it, and retries opening,
+	// copying and removing files that fail with ESTALE, for network
+	// filesystems.
+	NFSSafe bool
+	// RotateCopyTruncate rotates by copying the file to the archive and
+	// truncating it instead of renaming it, so readers keeping the file
+	// open, e.g. tail -f, go on reading the new lines. Unlike CopyTruncate,
+	// no lines are lost, as the Appender writes all of them. It takes
+	// precedence over NFSSafe.
+	RotateCopyTruncate bool
+	// SyncWrites opens the file with O_DSYNC, so every line is on the disk
+	// once Append returns, at the cost of waiting for the disk on every
+	// line.
+	SyncWrites bool
+	// DirectIO writes the file with O_DIRECT on Linux, bypassing the page
+	// cache, so a high volume of lines does not evict what other programs
+	// on the machine have cached. Lines are collected and written in
+	// blocks of 1 MiB, and on Sync and rotation. It falls back to the page
+	// cache if the filesystem does not support it. It cannot be combined
+	// with EncryptLive and RotateCopyTruncate.
+	DirectIO bool
+	// Shared lets several processes append to the same file, each with its
+	// own Appender. Every line is written with a single write in append
+	// mode while holding a shared lock on Path + ".rotate.lock", and the
+	// first of them to find the file full rotates it with an exclusive
+	// lock while the others follow it to the new file. It cannot be
+	// combined with EncryptLive, RotateCopyTruncate, NFSSafe, DirectIO,
+	// Preallocate, RotateOnStart and TruncateOnStart.
+	Shared bool
+	// RotationMarker is a file overwritten with a JSON line about the
+	// archive every time the file was rotated, for programs waiting for
+	// rotations with inotify. Empty disables it.
+	RotationMarker string
+	// RotationFIFO is a named pipe, created if missing, the JSON line of
+	// RotationMarker is written to on rotation if a program is reading it.
+	// Empty disables it.
+	RotationFIFO string
+	// Preallocate reserves MaxSize bytes of disk space for the file when it
+	// is opened, with fallocate on Linux, so a full disk shows up at
+	// rotation instead of in the middle of a file and the file is less
+	// fragmented. The space left over is freed when it is rotated.
+	Preallocate bool
+	// Sequence puts an increasing number in front of the timestamp of the
+	// archive names, saved in Path + ".seq", and orders archives by it, so
+	// their order survives steps of the clock.
+	Sequence bool
+	// RotateOnStart archives the file right away if it is not empty, so
+	// every Appender starts with a fresh file.
+	RotateOnStart bool
+	// TruncateOnStart empties the file right away, discarding what a
+	// previous Appender left in it instead of archiving it like
+	// RotateOnStart.
+	TruncateOnStart bool
+	// Numbered names the archives like logrotate does: the newest is
+	// Path.1, Path.1.gz once compressed, and on every rotation the others
+	// are renamed to the next higher number, so the oldest has the highest.
+	// Archives are named by their time at first and numbered in the
+	// background before they are compressed. As the names neither hold the
+	// time nor stay the same, it cannot be combined with Sequence, MaxAge,
+	// MaxFilesPerDay, Checksum, Manifest, Metadata and Shared.
+	Numbered bool
+}
+
+// ErrClosed is returned when appending to a closed Appender.
+var ErrClosed = errors.New("rotate: appender is closed")
+
+// Appender is the type responsible for appending and rotating files. Besides
+// Append it implements io.WriteCloser, so it can be used as the output of
+// loggers.
+type Appender struct {
+	opts    Options
+	metrics *Metrics
+
+	mu         sync.Mutex
+	file       *os.File
+	encryptor  *liveEncryptor
+	direct     *directWriter
+	sharedLock *os.File
+	fifo       *os.File
+	filePath   string
+	// path is filePath for Path, which must not wait for mu held by a
+	// hanging write.
+	path         atomic.Value
+	writer       *bufio.Writer
+	bytesWritten int
+	// fileLines counts the lines of the file for the metadata of encrypted
+	// files, which cannot be read to count them.
+	fileLines int64
+	maxSize   int
+	// maxFiles is read while processing archives, which must not take mu
+	// as rotation holds it while waiting for room in the queue.
+	maxFiles atomic.Int64
+	partial  []byte
+	// sharedLine is the line with its newline to write at once with
+	// Options.Shared.
+	sharedLine []byte
+	sequence   int64
+	// fileStarted is when the file was started empty, zero if unknown.
+	fileStarted time.Time
+	// rotateFailed is when rotating the file by its size failed last.
+	rotateFailed time.Time
+
+	fallbackSince  time.Time
+	primaryChecked time.Time
+	fileChecked    time.Time
+	spaceChecked   time.Time
+	lowSpace       bool
+	closed         bool
+	done           chan struct{}
+
+	wg           sync.WaitGroup
+	lastFileChan chan archiveJob
+	errors       chan error
+}
+
+// New opens the file at opts.Path for appending, creating it if needed.
+func New(opts Options) (*Appender, error) {
+	if opts.Path == "" {
+		return nil, errors.New("rotate: no path given")
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = NewMetrics()
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultRetryDelay
+	}
+	if opts.FallbackRetry <= 0 {
+		opts.FallbackRetry = DefaultFallbackRetry
+	}
+	if mixedRecipients(opts.EncryptRecipients) {
+		return nil, errors.New("rotate: EncryptRecipients cannot mix age public keys and GPG key ids")
+	}
+	if opts.EncryptLive && len(opts.EncryptRecipients) == 0 {
+		return nil, errors.New("rotate: EncryptLive needs EncryptRecipients")
+	}
+	if opts.EncryptLive && opts.RotateCopyTruncate {
+		return nil, errors.New("rotate: EncryptLive cannot be combined with RotateCopyTruncate")
+	}
+	if opts.DirectIO && (opts.EncryptLive || opts.RotateCopyTruncate) {
+		return nil, errors.New("rotate: DirectIO cannot be combined with EncryptLive or RotateCopyTruncate")
+	}
+	if opts.Shared && (opts.EncryptLive || opts.RotateCopyTruncate || opts.NFSSafe || opts.DirectIO || opts.Preallocate || opts.RotateOnStart || opts.TruncateOnStart) {
+		return nil, errors.New("rotate: Shared cannot be combined with EncryptLive, RotateCopyTruncate, NFSSafe, DirectIO, Preallocate, RotateOnStart or TruncateOnStart")
+	}
+	if opts.RotateOnStart && opts.TruncateOnStart {
+		return nil, errors.New("rotate: RotateOnStart cannot be combined with TruncateOnStart")
+	}
+	if opts.Numbered && (opts.Sequence || opts.MaxAge > 0 || opts.MaxFilesPerDay > 0 || opts.Checksum || opts.Manifest || opts.Metadata || opts.Shared) {
+		return nil, errors.New("rotate: Numbered cannot be combined with Sequence, MaxAge, MaxFilesPerDay, Checksum, Manifest, Metadata or Shared")
+	}
+
+	a := &Appender{
+		opts:         opts,
+		metrics:      opts.Metrics,
+		maxSize:      opts.MaxSize,
+		lastFileChan: make(chan archiveJob, opts.QueueSize),
+		done:         make(chan struct{}),
+		errors:       make(chan error, errorQueueSize),
+	}
+	a.setFilePath(opts.Path)
+	a.maxFiles.Store(int64(opts.MaxFiles))
+	if err := a.resolveSymlinks(); err != nil {
+		return nil, fmt.Errorf("rotate: cannot resolve symbolic link: %v", err)
+	}
+	if opts.Shared {
+		if err := a.openSharedLock(); err != nil {
+			return nil, fmt.Errorf("rotate: cannot open lock: %v", err)
+		}
+	}
+	if opts.RotationFIFO != "" {
+		if err := a.createRotationFIFO(); err != nil {
+			return nil, fmt.Errorf("rotate: cannot create rotation FIFO: %v", err)
+		}
+	}
+	if opts.Sequence {
+		if err := a.loadSequence(); err != nil {
+			return nil, fmt.Errorf("rotate: cannot read sequence number: %v", err)
+		}
+	}
+	if opts.TruncateOnStart {
+		if err := a.discardLeftover(); err != nil {
+			return nil, fmt.Errorf("rotate: cannot truncate file: %v", err)
+		}
+	}
+	if err := a.openFile(); err != nil {
+		return nil, err
+	}
+	go a.manageFiles()
+	if !opts.CompressWindow.IsZero() {
+		go a.scheduleCompression()
+	}
+	if opts.Compress || len(opts.EncryptRecipients) > 0 || opts.RetentionDryRun || opts.Manifest || opts.MaxAge > 0 || opts.MaxFilesPerDay > 0 || opts.MaxTotalSize > 0 || opts.Numbered {
+		a.resumePending()
+	}
+	if opts.RotateOnStart && a.bytesWritten > 0 {
+		if err := a.rotateFile(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Path returns the path of the file being appended to. It differs from
+// Options.Path while writing to the fallback. It does not wait for a write
+// in progress.
+func (a *Appender) Path() string {
+	filePath, _ := a.path.Load().(string)
+	return filePath
+}
+
+// setFilePath changes the path of the file being appended to.
+func (a *Appender) setFilePath(filePath string) {
+	a.filePath = filePath
+	a.path.Store(filePath)
+}
+
+// Metrics returns the metrics the Appender records its activity in.
+func (a *Appender) Metrics() *Metrics {
+	return a.metrics
+}
+
+// SetMaxSize changes the size at which the file is rotated.
+func (a *Appender) SetMaxSize(maxSize int) {
+	a.mu.Lock()
+	a.maxSize = maxSize
+	a.mu.Unlock()
+}
+
+// SetMaxFiles changes the number of archives to keep from the next rotation.
+func (a *Appender) SetMaxFiles(maxFiles int) {
+	a.maxFiles.Store(int64(maxFiles))
+}
+
+// Rotate archives the file now unless it is empty.
+func (a *Appender) Rotate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+	if a.opts.Shared {
+		if err := a.flockShared(syscall.LOCK_EX); err != nil {
+			return err
+		}
+		defer a.flockShared(syscall.LOCK_UN)
+		if err := a.followShared(); err != nil {
+			return err
+		}
+	}
+	if a.bytesWritten == 0 {
+		return nil
+	}
+	return a.rotateFile()
+}
+
+var _ io.WriteCloser = (*Appender)(nil)
+
+// Append inserts line at the end of file and asks file to be rotated if it is too big.
+func (a *Appender) Append(line string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.appendLine([]byte(line))
+}
+
+// AppendBytes appends line like Append without converting it. line is not
+// retained, so the caller may reuse it, e.g. the buffer of a bufio.Scanner.
+func (a *Appender) AppendBytes(line []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.appendLine(line)
+}
+
+// Write appends the newline terminated lines in p. A trailing incomplete
+// line is kept until a later Write completes it or the Appender is closed,
+// so lines are never split between files.
+func (a *Appender) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data := p
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			a.partial = append(a.partial, data...)
+			return len(p), nil
+		}
+
+		line := data[:i]
+		if len(a.partial) > 0 {
+			line = append(a.partial, line...)
+			a.partial = a.partial[:0]
+		}
+		if err := a.appendLine(line); err != nil {
+			return len(p) - len(data), err
+		}
+		data = data[i+1:]
+	}
+}
+
+// Sync flushes the lines written so far to the disk, so they survive a crash
+// of the machine and not only of the process.
+func (a *Appender) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+	err := a.writer.Flush()
+	if err == nil && a.encryptor != nil {
+		err = a.encryptor.Flush()
+	}
+	if err == nil && a.direct != nil {
+		err = a.direct.finish()
+	}
+	if err == nil {
+		err = a.file.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	a.metrics.Add("syncs", 1)
+	return nil
+}
+
+func (a *Appender) appendLine(line []byte) error {
+	if a.opts.MinFreeSpace > 0 && time.Since(a.spaceChecked) >= spaceCheckInterval {
+		a.checkSpace()
+	}
+	if a.closed {
+		return ErrClosed
+	}
+	if a.fallbackSince != (time.Time{}) && time.Since(a.primaryChecked) >= a.opts.FallbackRetry {
+		a.returnFromFallback()
+	}
+
+	// Shared files are checked on every write.
+	if a.opts.StatInterval > 0 && !a.opts.Shared && time.Since(a.fileChecked) >= a.opts.StatInterval {
+		a.checkFile()
+	}
+
+	var err error
+	if a.opts.Shared {
+		err = a.writeShared(line)
+	} else if err = a.rotateIfFull(); err == nil {
+		err = a.writeLine(line)
+	}
+	if err != nil && a.opts.FallbackPath != "" && a.fallbackSince == (time.Time{}) {
+		if a.switchToFallback(err) == nil {
+			err = a.writeLine(line)
+		}
+	}
+	return err
+}
+
+// checkFile reopens the file if its path no longer leads to the open file,
+// so lines do not go to a deleted file, and takes over its size if it
+// differs from the bytes written, e.g. because it was truncated or others
+// append to it.
+func (a *Appender) checkFile() {
+	a.fileChecked = time.Now()
+
+	open, err := a.file.Stat()
+	if err != nil {
+		return
+	}
+	current, err := os.Stat(a.filePath)
+	if err != nil || !os.SameFile(open, current) {
+		a.log(LevelWarn, "file_replaced", Fields{"file": a.filePath}, a.filePath, "was deleted or replaced, reopening it")
+		a.metrics.Add("reopens", 1)
+		a.closeFile()
+		a.openFile()
+		return
+	}
+
+	// The size of an encrypted file says nothing about the lines in it,
+	// that of a direct one lags behind the buffered lines. The apparent
+	// size is used, so filesystem compression does not change when the
+	// file is rotated.
+	if a.encryptor != nil || a.direct != nil || current.Size() == int64(a.bytesWritten) {
+		return
+	}
+	if current.Size() < int64(a.bytesWritten) {
+		a.log(LevelWarn, "file_truncated", Fields{"file": a.filePath, "size": current.Size(), "expected_size": a.bytesWritten},
+			a.filePath, "was truncated to", current.Size(), "bytes")
+	} else {
+		a.log(LevelInfo, "file_grown", Fields{"file": a.filePath, "size": current.Size(), "expected_size": a.bytesWritten},
+			a.filePath, "was appended to by others, it has", current.Size()-int64(a.bytesWritten), "bytes more than written")
+	}
+	a.metrics.Add("size_reconciled", 1)
+	a.bytesWritten = int(current.Size())
+}
+
+func (a *Appender) rotateIfFull() error {
+	if a.bytesWritten >= a.maxSize && time.Since(a.rotateFailed) >= rotateRetryInterval {
+		return a.rotateFile()
+	}
+	return nil
+}
+
+func (a *Appender) writeLine(line []byte) error {
+	var n int
+	var err error
+	if a.opts.Shared {
+		// A single write, so the lines of other processes cannot end up
+		// between the line and its newline.
+		a.sharedLine = append(append(a.sharedLine[:0], line...), '\n')
+		n, err = a.writer.Write(a.sharedLine)
+		n--
+	} else if n, err = a.writer.Write(line); err == nil {
+		err = a.writer.WriteByte('\n')
+	}
+	if err == nil {
+		err = a.writer.Flush()
+	}
+	if err != nil {
+		// The bufio.Writer keeps failing after an error, start over with
+		// the next line.
+		a.writer.Reset(fileWriter{a})
+		a.metrics.Add("lines_lost", 1)
+		return err
+	}
+
+	a.fileLines++
+	a.metrics.Add("lines", 1)
+	a.metrics.Add("bytes", int64(n+1))
+	return nil
+}
+
+// Close appends a pending incomplete line, flushes and closes the file and
+// waits for the archives to be processed. Closing twice returns ErrClosed.
+func (a *Appender) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return ErrClosed
+	}
+	var err error
+	if len(a.partial) > 0 {
+		err = a.appendLine(a.partial)
+		a.partial = nil
+	}
+	if closeErr := a.closeFile(); err == nil {
+		err = closeErr
+	}
+	a.closed = true
+	close(a.done)
+	a.mu.Unlock()
+
+	a.wg.Wait()
+	close(a.lastFileChan)
+	close(a.errors)
+	if a.sharedLock != nil {
+		a.sharedLock.Close()
+	}
+	if a.fifo != nil {
+		a.fifo.Close()
+	}
+	return err
+}
+
+func (a *Appender) openFile() error {
+	if a.opts.EncryptLive {
+		if err := a.archiveLeftover(); err != nil {
+			a.logFailure("open_failed", a.filePath, err, "cannot archive file to encrypt the new one:")
+			return err
+		}
+	}
+
+	flags := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	if a.opts.SyncWrites {
+		flags |= syscall.O_DSYNC
+	}
+	var f *os.File
+	direct := false
+	err := a.retryStale(func() (err error) {
+		if a.opts.DirectIO {
+			f, direct, err = a.openDirect(flags)
+			return err
+		}
+		f, err = os.OpenFile(a.filePath, flags, 0644)
+		return err
+	})
+	if err != nil {
+		a.logFailure("open_failed", a.filePath, err, "cannot open file:")
+		return err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	a.direct = nil
+	if direct {
+		if a.direct, err = newDirectWriter(f); err != nil {
+			f.Close()
+			a.logFailure("open_failed", a.filePath, err, "cannot open file for direct I/O:")
+			return err
+		}
+	}
+	a.encryptor = nil
+	if a.opts.EncryptLive {
+		if a.encryptor, err = startLiveEncryptor(f, a.opts.EncryptRecipients, a.opts.Compress); err != nil {
+			f.Close()
+			a.logFailure("open_failed", a.filePath, err, "cannot start encrypting file:")
+			return err
+		}
+	}
+
+	a.file = f
+	a.writer = bufio.NewWriter(fileWriter{a})
+	a.bytesWritten = int(st.Size())
+	a.fileLines = 0
+	a.fileStarted = time.Time{}
+	if a.bytesWritten == 0 {
+		a.fileStarted = a.opts.Clock()
+	}
+	a.preallocate()
+	return nil
+}
+
+// discardLeftover empties what a previous process left in the file with
+// Options.TruncateOnStart.
+func (a *Appender) discardLeftover() error {
+	info, err := os.Stat(a.filePath)
+	if os.IsNotExist(err) || err == nil && info.Size() == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Truncate(a.filePath, 0); err != nil {
+		return err
+	}
+	a.log(LevelInfo, "file_discarded", Fields{"file": a.filePath, "size": info.Size()},
+		"discarded", info.Size(), "bytes left in", a.filePath)
+	return nil
+}
+
+// resumePending queues processing the archives a previous process left
+// behind unprocessed.
+func (a *Appender) resumePending() {
+	paths := []string{a.opts.Path}
+	if a.opts.FallbackPath != "" {
+		paths = append(paths, a.opts.FallbackPath)
+	}
+	for _, filePath := range paths {
+		a.wg.Add(1)
+		a.lastFileChan <- archiveJob{path: filePath}
+	}
+}
+
+func (a *Appender) closeFile() error {
+	err := a.writer.Flush()
+	if err == nil && a.direct != nil {
+		err = a.direct.finish()
+	}
+	a.releasePreallocated()
+	if a.encryptor != nil {
+		if closeErr := a.encryptor.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rotateRetryInterval is how long the file grows beyond MaxSize after a
+// failed rotation, so a failing copy is not repeated for every line.
+const rotateRetryInterval = 10 * time.Second
+
+func (a *Appender) rotateFile() error {
+	start := time.Now()
+	archiveName := a.archiveFileName()
+	if a.opts.EncryptLive {
+		archiveName += a.liveExt()
+	}
+	size := a.bytesWritten
+	job := archiveJob{path: a.filePath, archive: archiveName, started: a.fileStarted, lines: a.fileLines, size: int64(size)}
+	switch {
+	case a.opts.RotateCopyTruncate:
+		if err := a.truncateToArchive(archiveName); err != nil {
+			a.rotateFailed = time.Now()
+			a.fail("rotate_failed", a.filePath, err, "cannot copy file to archive:")
+			return err
+		}
+	case a.opts.NFSSafe:
+		a.closeFile()
+		if err := a.copyToArchive(archiveName); err != nil {
+			a.rotateFailed = time.Now()
+			a.fail("rotate_failed", a.filePath, err, "cannot copy file to archive:")
+			if openErr := a.openFile(); openErr != nil {
+				return openErr
+			}
+			return err
+		}
+	default:
+		if err := a.closeFile(); err != nil {
+			// The file is closed regardless, only buffered lines are lost.
+			a.fail("rotate_failed", a.filePath, err, "cannot close file for rotating it:")
+		}
+		if err := os.Rename(a.filePath, archiveName); err != nil {
+			a.rotateFailed = time.Now()
+			a.fail("rotate_failed", a.filePath, err, "cannot rename file to archive:")
+			if openErr := a.openFile(); openErr != nil {
+				return openErr
+			}
+			return err
+		}
+	}
+	a.rotateFailed = time.Time{}
+	a.queueArchive(job)
+
+	if !a.opts.RotateCopyTruncate {
+		if err := a.openFile(); err != nil {
+			return err
+		}
+	}
+	duration := time.Since(start)
+	a.metrics.Add("rotations", 1)
+	a.metrics.Time("rotate", duration)
+	a.log(LevelDebug, "rotate", Fields{"file": a.filePath, "archive": archiveName, "size": size, "duration_ms": durationMillis(duration)},
+		"rotated", a.filePath, "at", size, "bytes to", archiveName)
+	if !a.opts.Numbered {
+		// Numbered archives are announced once they are numbered.
+		a.announceRotation(a.filePath, archiveName, int64(size))
+	}
+	return nil
+}
+
+// archiveFileName returns the name for the next archive. If an archive of
+// the same time exists, e.g. because the clock stepped backwards, a
+// sequence number is appended instead of overwriting it.
+func (a *Appender) archiveFileName() string {
+	now := a.opts.Clock()
+	if a.opts.UTC {
+		now = now.UTC()
+	}
+	ts := now.Format(archiveTimeFormat)
+	if a.opts.Sequence {
+		ts = fmt.Sprintf("%06d_%s", a.nextSequence(), ts)
+	}
+	name := a.filePath + "_" + ts
+	for seq := 1; archiveExists(name); seq++ {
+		name = fmt.Sprintf("%s_%s_%03d", a.filePath, ts, seq)
+	}
+	return name
+}
+
+// archiveTimeFormat is the layout of the time of rotation in archive names.
+const archiveTimeFormat = "2006-01-02T15.04.05.000000000Z0700"
+
+// archiveExists reports whether there is an archive called name in any
+// stage of processing.
+func archiveExists(name string) bool {
+	for _, ext := range []string{"", ".gz", ".age", ".gpg", ".gz.age", ".gz.gpg"} {
+		if _, err := os.Lstat(name + ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// durationMillis converts d to fractional milliseconds for log fields.
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
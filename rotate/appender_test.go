@@ -0,0 +1,390 @@
+package rotate
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock returns a clock starting at a fixed time and advancing by a
+// second on every call, so archive names are predictable and distinct.
+// Processing archives reads it too.
+func testClock() func() time.Time {
+	var mu sync.Mutex
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+// newTestAppender returns an Appender for a file in a temporary directory
+// with opts, closed at the end of the test.
+func newTestAppender(t *testing.T, opts Options) *Appender {
+	t.Helper()
+	opts.Path = path.Join(t.TempDir(), "app.log")
+	if opts.Clock == nil {
+		opts.Clock = testClock()
+	}
+	a, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+// readFile returns the content of fileName, failing the test if it cannot
+// be read.
+func readFile(t *testing.T, fileName string) string {
+	t.Helper()
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// archiveContents returns the contents of the archives of a, oldest first.
+func archiveContents(t *testing.T, a *Appender) []string {
+	t.Helper()
+	infos, err := ioutil.ReadDir(path.Dir(a.Path()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), path.Base(a.Path())+"_") {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	contents := []string{}
+	for _, name := range names {
+		contents = append(contents, readFile(t, path.Join(path.Dir(a.Path()), name)))
+	}
+	return contents
+}
+
+func TestAppendRotatesBySize(t *testing.T) {
+	a := newTestAppender(t, Options{MaxSize: 10, MaxFiles: 10})
+	for _, line := range []string{"line 0 ...", "line 1 ...", "line 2 ..."} {
+		if err := a.Append(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := archiveContents(t, a), []string{"line 0 ...\n", "line 1 ...\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archives = %q, want %q", got, want)
+	}
+	if got, want := readFile(t, a.Path()), "line 2 ...\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestAppendBytesDoesNotRetainLine(t *testing.T) {
+	a := newTestAppender(t, Options{})
+	line := []byte("first")
+	if err := a.AppendBytes(line); err != nil {
+		t.Fatal(err)
+	}
+	copy(line, "reuse")
+	if err := a.AppendBytes(line[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readFile(t, a.Path()), "first\nreu\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestWriteKeepsPartialLine(t *testing.T) {
+	a := newTestAppender(t, Options{})
+	if _, err := a.Write([]byte("one\ntw")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte("o\nthree")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, a.Path()), "one\ntwo\n"; got != want {
+		t.Errorf("file before Close = %q, want %q", got, want)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, a.Path()), "one\ntwo\nthree\n"; got != want {
+		t.Errorf("file after Close = %q, want %q", got, want)
+	}
+}
+
+func TestAppendAfterClose(t *testing.T) {
+	a := newTestAppender(t, Options{})
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Append("line"); err != ErrClosed {
+		t.Errorf("Append after Close = %v, want %v", err, ErrClosed)
+	}
+	if err := a.Close(); err != ErrClosed {
+		t.Errorf("second Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestNewRejectsMixedRecipients(t *testing.T) {
+	_, err := New(Options{Path: path.Join(t.TempDir(), "app.log"), EncryptRecipients: []string{"age1abc", "ops@example.com"}})
+	if err == nil {
+		t.Error("New with age and GPG recipients succeeded")
+	}
+}
+
+func TestRetentionMaxFiles(t *testing.T) {
+	a := newTestAppender(t, Options{MaxSize: 1, MaxFiles: 2})
+	for _, line := range []string{"0", "1", "2", "3", "4"} {
+		if err := a.Append(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := archiveContents(t, a), []string{"2\n", "3\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archives = %q, want %q", got, want)
+	}
+}
+
+func TestRetentionMaxTotalSize(t *testing.T) {
+	a := newTestAppender(t, Options{MaxSize: 1, MaxFiles: 10, MaxTotalSize: 5})
+	for _, line := range []string{"00", "11", "22", "33"} {
+		if err := a.Append(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := archiveContents(t, a), []string{"22\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archives = %q, want %q", got, want)
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	a := newTestAppender(t, Options{MaxSize: 1, MaxAge: time.Hour, Clock: clock})
+	if err := a.Append("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+	if err := a.Append("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := archiveContents(t, a), []string{"new\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archives = %q, want %q", got, want)
+	}
+}
+
+func TestReopenDeletedFile(t *testing.T) {
+	a := newTestAppender(t, Options{StatInterval: time.Nanosecond})
+	if err := a.Append("before"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(a.Path()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := a.Append("after"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readFile(t, a.Path()), "after\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+	if counters, _ := a.Metrics().Snapshot(); counters["reopens"] != 1 {
+		t.Errorf("reopens = %d, want 1", counters["reopens"])
+	}
+}
+
+func TestReopenExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := path.Join(dir, "app.log")
+	if err := ioutil.WriteFile(fileName, []byte("0123456789\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Options{Path: fileName, MaxSize: 15, MaxFiles: 10, Clock: testClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"abc", "def"} {
+		if err := a.Append(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The existing size counts towards MaxSize.
+	if got, want := archiveContents(t, a), []string{"0123456789\nabc\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archives = %q, want %q", got, want)
+	}
+	if got, want := readFile(t, fileName), "def\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestRotateFile(t *testing.T) {
+	a := newTestAppender(t, Options{MaxFiles: 10})
+	if err := a.Append("line"); err != nil {
+		t.Fatal(err)
+	}
+
+	a.mu.Lock()
+	err := a.rotateFile()
+	bytesWritten := a.bytesWritten
+	a.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytesWritten != 0 {
+		t.Errorf("bytesWritten = %d after rotation, want 0", bytesWritten)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := archiveContents(t, a), []string{"line\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archives = %q, want %q", got, want)
+	}
+	if got := readFile(t, a.Path()); got != "" {
+		t.Errorf("file = %q after rotation, want it empty", got)
+	}
+}
+
+func TestRotateFileBacksOffAfterFailure(t *testing.T) {
+	a := newTestAppender(t, Options{MaxSize: 1})
+	if err := a.Append("line"); err != nil {
+		t.Fatal(err)
+	}
+	// Renaming the file to the archive fails once it is gone.
+	if err := os.Remove(a.Path()); err != nil {
+		t.Fatal(err)
+	}
+
+	a.mu.Lock()
+	err := a.rotateFile()
+	a.mu.Unlock()
+	if err == nil {
+		t.Fatal("rotateFile succeeded without the file")
+	}
+	var failure *Error
+	if reported := <-a.Errors(); !errors.As(reported, &failure) || failure.Event != "rotate_failed" {
+		t.Errorf("reported %v, want a rotate_failed error", reported)
+	}
+
+	// The file is reopened and over MaxSize, but not rotated again yet.
+	for _, line := range []string{"next", "last"} {
+		if err := a.Append(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if archives := archiveContents(t, a); len(archives) != 0 {
+		t.Errorf("archives = %q, want none", archives)
+	}
+	if got, want := readFile(t, a.Path()), "next\nlast\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestArchiveNamesUseClock(t *testing.T) {
+	a := newTestAppender(t, Options{MaxSize: 1, MaxFiles: 10, UTC: true})
+	for _, line := range []string{"0", "1"} {
+		if err := a.Append(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archives, err := Archives(a.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The clock starts at 03:04:05 and ticks by a second on every call.
+	if len(archives) != 1 || !strings.HasPrefix(path.Base(archives[0]), "app.log_2020-01-02T03.04.0") || !strings.HasSuffix(archives[0], ".000000000Z") {
+		t.Errorf("archives = %q, want one named by the clock in UTC", archives)
+	}
+}
+
+// benchmarkLine is a line of a typical length for the benchmarks.
+var benchmarkLine = []byte(strings.Repeat("x", 199))
+
+func BenchmarkAppendBytes(b *testing.B) {
+	a, err := New(Options{Path: path.Join(b.TempDir(), "app.log"), MaxSize: 1 << 30})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer a.Close()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkLine) + 1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.AppendBytes(benchmarkLine); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppend(b *testing.B) {
+	a, err := New(Options{Path: path.Join(b.TempDir(), "app.log"), MaxSize: 1 << 30})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer a.Close()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkLine) + 1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.Append(string(benchmarkLine)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package rotate
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// archiveJob is a freshly rotated archive of the file at path. Without an
+// archive all archives of path still waiting to be processed are.
+type archiveJob struct {
+	path    string
+	archive string
+	// started is when the first line of the archive was written, zero if
+	// unknown.
+	started time.Time
+	// lines and size are what was written to the archive, used for
+	// encrypted ones and announcing numbered ones. They are zero if
+	// unknown.
+	lines, size int64
+}
+
+func (a *Appender) manageFiles() {
+	if a.opts.LowPriority {
+		// The thread ends with the goroutine, so it is never reused.
+		runtime.LockOSThread()
+		if err := lowerPriority(); err != nil {
+			a.log(LevelWarn, "priority_failed", Fields{"error": err.Error()}, "cannot lower the priority of compression:", err)
+		}
+	}
+	for job := range a.lastFileChan {
+		if a.opts.Numbered {
+			// This also numbers the archives of earlier jobs that were
+			// skipped or dropped and, at startup, those a previous process
+			// left.
+			job.archive = a.numberArchives(job.path, job.archive)
+			if job.archive != "" {
+				a.announceRotation(job.path, job.archive, job.size)
+			}
+		}
+		if job.archive == "" {
+			a.removeTemporaryFiles(job.path)
+		} else {
+			// Count the lines while the archive can still be read.
+			if a.opts.Metadata {
+				a.writeMetadata(job)
+			}
+			if a.opts.Manifest {
+				a.updateManifest(job.path)
+			}
+		}
+		switch {
+		case job.archive == "" || a.opts.Compress && a.opts.KeepUncompressed > 0:
+			a.processPending(job.path)
+		case !a.inCompressWindow():
+			a.metrics.Add("compress_deferred", 1)
+			a.log(LevelDebug, "compress_deferred", Fields{"file": job.archive, "window": a.opts.CompressWindow.String()},
+				"leaving", job.archive, "for the compression window", a.opts.CompressWindow)
+		default:
+			a.processArchive(job.archive)
+		}
+		a.removeOldFiles(job.path)
+		if a.opts.Manifest {
+			a.updateManifest(job.path)
+		}
+		a.wg.Done()
+	}
+}
+
+// processPending processes the archives of filePath that are not yet
+// compressed or encrypted as configured, but the newest KeepUncompressed
+// ones. They are left behind by KeepUncompressed, outside CompressWindow or
+// if the process was killed before it got to them.
+func (a *Appender) processPending(filePath string) {
+	if !a.opts.Compress && len(a.opts.EncryptRecipients) == 0 {
+		return
+	}
+	archives, err := listArchives(filePath, a.opts.Numbered)
+	if err != nil {
+		a.fail("compress_failed", filePath, err, "cannot list archives:")
+		return
+	}
+
+	pending := []string{}
+	for _, name := range archives {
+		if !a.isProcessed(name) {
+			pending = append(pending, name)
+		}
+	}
+	keep := 0
+	if a.opts.Compress {
+		keep = a.opts.KeepUncompressed
+	}
+	for index := 0; index < len(pending)-keep; index++ {
+		// Archives left when the window closes wait for the next one.
+		if !a.inCompressWindow() {
+			break
+		}
+		a.processArchive(path.Join(path.Dir(filePath), pending[index]))
+	}
+}
+
+// isProcessed reports whether the archive name was compressed and encrypted
+// as configured.
+func (a *Appender) isProcessed(name string) bool {
+	switch path.Ext(name) {
+	case ".age", ".gpg":
+		return true
+	case ".gz", ".zst":
+		return len(a.opts.EncryptRecipients) == 0
+	}
+	return false
+}
+
+// tmpSuffix is appended to compressed and encrypted archives until they are
+// complete. Leftovers of a killed process are removed on startup.
+const tmpSuffix = ".tmp"
+
+// syncFile flushes fileName to disk.
+func syncFile(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (a *Appender) removeTemporaryFiles(filePath string) {
+	infos, err := ioutil.ReadDir(path.Dir(filePath))
+	if err != nil {
+		return
+	}
+	pattern := archivePattern(path.Base(filePath))
+	numberPattern := numberedPattern(path.Base(filePath))
+	for _, info := range infos {
+		name := info.Name()
+		archive := strings.TrimSuffix(name, tmpSuffix)
+		if archive == name || !pattern.MatchString(archive) && !(a.opts.Numbered && numberPattern.MatchString(archive)) {
+			continue
+		}
+		fileName := path.Join(path.Dir(filePath), name)
+		if err := os.Remove(fileName); err != nil {
+			a.fail("delete_failed", fileName, err)
+			continue
+		}
+		a.log(LevelInfo, "delete", Fields{"file": fileName}, "removed incomplete archive", fileName)
+	}
+}
+
+// processArchive compresses, encrypts and checksums a freshly rotated file
+// as configured. It stops at the first step that fails.
+func (a *Appender) processArchive(lastFile string) {
+	// The archives pending at startup are processed by a job queued before
+	// the first rotation, which already took a rotation on start along.
+	if _, err := os.Lstat(lastFile); os.IsNotExist(err) && archiveExists(lastFile) {
+		return
+	}
+	// Archives encrypted while they were written only need a checksum.
+	ext := path.Ext(lastFile)
+	encrypted := ext == ".age" || ext == ".gpg"
+	if a.opts.Compress && !encrypted && !strings.HasSuffix(lastFile, ".gz") {
+		if err := a.compressFile(lastFile); err != nil {
+			a.fail("compress_failed", lastFile, err, "cannot compress file:")
+			return
+		}
+		lastFile += ".gz"
+	}
+	if len(a.opts.EncryptRecipients) > 0 && !encrypted {
+		encrypted, err := encryptFile(lastFile, a.opts.EncryptRecipients)
+		if err != nil {
+			a.fail("encrypt_failed", lastFile, err, "cannot encrypt file:")
+			return
+		}
+		lastFile = encrypted
+	}
+	if a.opts.Checksum {
+		if err := writeChecksum(lastFile); err != nil {
+			a.fail("checksum_failed", lastFile, err, "cannot write checksum file:")
+		}
+	}
+}
+
+// archiveSuffixPattern matches what archiveFileName appends to the file
+// name, the optional sequence number, the timestamp and the number telling
+// archives of the same time apart, followed by the extensions of
+// compression and encryption.
+const archiveSuffixPattern = `_(?:(\d{6,})_)?(\d{4}-\d{2}-\d{2}T\d{2}\.\d{2}\.\d{2}\.\d{9}(Z|[+-]\d{4}))(_\d{3,})?(\.gz|\.zst)?(\.age|\.gpg)?$`
+
+// archivePattern matches the names of the archives of baseName and nothing
+// else, e.g. not the archives of "app.log_backup" for "app.log".
+func archivePattern(baseName string) *regexp.Regexp {
+	return regexp.MustCompile("^" + regexp.QuoteMeta(baseName) + archiveSuffixPattern)
+}
+
+// listArchives returns the names of the archives of filePath, oldest first,
+// with numbered also the numbered ones of Options.Numbered.
+func listArchives(filePath string, numbered bool) ([]string, error) {
+	infos, err := ioutil.ReadDir(path.Dir(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	archives := []string{}
+	pattern := archivePattern(path.Base(filePath))
+	numberPattern := numberedPattern(path.Base(filePath))
+	for _, info := range infos {
+		if pattern.MatchString(info.Name()) || numbered && numberPattern.MatchString(info.Name()) {
+			archives = append(archives, info.Name())
+		}
+	}
+
+	sortArchives(archives)
+	return archives, nil
+}
+
+func (a *Appender) removeOldFiles(filePath string) {
+	keep := int(a.maxFiles.Load())
+	maxAge := a.opts.MaxAge
+	perDay := a.opts.MaxFilesPerDay
+	maxTotal := a.opts.MaxTotalSize
+	if keep <= 0 && maxAge <= 0 && perDay <= 0 && maxTotal <= 0 {
+		return
+	}
+
+	archives, err := listArchives(filePath, a.opts.Numbered)
+	if err != nil {
+		a.fail("retention_failed", filePath, err)
+		return
+	}
+	dir := path.Dir(filePath)
+
+	a.log(LevelDebug, "retention", Fields{"file": filePath, "archives": len(archives), "max_files": keep, "max_age": maxAge.String(), "max_files_per_day": perDay, "max_total_size": maxTotal},
+		"found", len(archives), "archives, keeping", keep)
+	// The archives beyond the cap of their day do not count for MaxFiles,
+	// so a burst of rotations cannot push out the older days.
+	var remove []string
+	if perDay > 0 {
+		remove, archives = perDayExcess(archives, perDay)
+	}
+	now := a.opts.Clock()
+	var kept []string
+	for index, name := range archives {
+		if a.expired(name, index, len(archives), keep, maxAge, now) {
+			remove = append(remove, name)
+		} else {
+			kept = append(kept, name)
+		}
+	}
+	if maxTotal > 0 {
+		remove = append(remove, totalSizeExcess(dir, kept, maxTotal)...)
+	}
+
+	for _, name := range remove {
+		fileName := path.Join(dir, name)
+		if a.opts.VerifyChecksum {
+			ok, err := checksumMatches(fileName)
+			if err != nil {
+				a.fail("checksum_failed", fileName, err, "cannot verify checksum:")
+				continue
+			}
+			if !ok {
+				a.log(LevelError, "checksum_mismatch", Fields{"file": fileName}, "checksum mismatch, keeping", fileName)
+				continue
+			}
+		}
+		if a.opts.RetentionDryRun {
+			a.log(LevelInfo, "retention_dry_run", Fields{"file": fileName}, "would remove old archive", fileName)
+			continue
+		}
+
+		err := os.Remove(fileName)
+		if err != nil {
+			a.fail("delete_failed", fileName, err)
+			continue
+		}
+		a.metrics.Add("deletions", 1)
+		a.log(LevelDebug, "delete", Fields{"file": fileName}, "removed old archive", fileName)
+		for _, sidecar := range []string{fileName + checksumSuffix, metadataFileName(fileName)} {
+			if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+				a.fail("delete_failed", sidecar, err)
+			}
+		}
+	}
+}
+
+// purgeOldest removes the oldest archive to free disk space regardless of
+// the retention settings, logging reason. It reports whether there was one
+// to remove.
+func (a *Appender) purgeOldest(reason string) bool {
+	archives, err := listArchives(a.filePath, a.opts.Numbered)
+	if err != nil || len(archives) == 0 {
+		return false
+	}
+
+	fileName := path.Join(path.Dir(a.filePath), archives[0])
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		a.logFailure("delete_failed", fileName, err)
+		return false
+	}
+	os.Remove(fileName + checksumSuffix)
+	os.Remove(metadataFileName(fileName))
+	a.metrics.Add("emergency_deletions", 1)
+	a.log(LevelWarn, "emergency_delete", Fields{"file": fileName}, reason+", removed oldest archive", fileName)
+	return true
+}
+
+func (a *Appender) compressFile(fileName string) error {
+	start := time.Now()
+	inFile, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	// Write to a temporary file, so a crash does not leave a truncated
+	// archive next to the original.
+	tmpName := fileName + ".gz" + tmpSuffix
+	outFile, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = inFile
+	if a.opts.CompressBandwidth > 0 {
+		r = newThrottledReader(inFile, a.opts.CompressBandwidth)
+	}
+	w := gzip.NewWriter(outFile)
+	size, err := io.Copy(w, r)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = outFile.Sync()
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = a.verifyCompressed(inFile, tmpName, size)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName+".gz")
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	os.Remove(fileName)
+	duration := time.Since(start)
+	a.metrics.Add("compressions", 1)
+	a.metrics.Time("compress", duration)
+	a.log(LevelDebug, "compress", Fields{"file": fileName, "size": size, "duration_ms": durationMillis(duration)},
+		"compressed", fileName, "in", duration)
+	return nil
+}
+
+// verifyCompressed decompresses the archive compressed from in to check it
+// before the original is removed. The gzip reader verifies the checksum and
+// size in the trailer, and the size has to match the original's and what
+// was compressed, so a truncated archive is never left alone.
+func (a *Appender) verifyCompressed(in *os.File, gzName string, size int64) error {
+	uncompressed, err := gzipSize(gzName)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = in.Stat(); err == nil && (uncompressed != size || info.Size() != size) {
+			err = fmt.Errorf("decompresses to %d bytes, compressed %d of %d", uncompressed, size, info.Size())
+		}
+	}
+	if err != nil {
+		a.metrics.Add("compress_verify_failed", 1)
+		return fmt.Errorf("cannot verify compressed file: %v", err)
+	}
+	return nil
+}
+
+// gzipSize returns the uncompressed size of the gzip file fileName.
+func gzipSize(fileName string) (int64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(ioutil.Discard, r)
+}
@@ -0,0 +1,64 @@
+package rotate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+const checksumSuffix = ".sha256"
+
+// fileChecksum returns the hex encoded SHA-256 of the contents of fileName.
+func fileChecksum(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum writes fileName.sha256 in the format of sha256sum, so it can
+// also be checked with "sha256sum -c".
+func writeChecksum(fileName string) error {
+	sum, err := fileChecksum(fileName)
+	if err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("%s  %s\n", sum, path.Base(fileName))
+	return ioutil.WriteFile(fileName+checksumSuffix, []byte(line), 0644)
+}
+
+// checksumMatches reports whether fileName matches its .sha256 file. Files
+// without a checksum file are considered valid.
+func checksumMatches(fileName string) (bool, error) {
+	content, err := ioutil.ReadFile(fileName + checksumSuffix)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return false, fmt.Errorf("empty checksum file %s", fileName+checksumSuffix)
+	}
+
+	sum, err := fileChecksum(fileName)
+	if err != nil {
+		return false, err
+	}
+	return sum == fields[0], nil
+}
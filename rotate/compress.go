@@ -0,0 +1,52 @@
+package rotate
+
+import (
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// CompressPending compresses, encrypts and checksums the archives of
+// opts.Path and opts.FallbackPath that are not yet as opts configures, but
+// the newest KeepUncompressed ones, once and regardless of CompressWindow,
+// like a new Appender does in the background. Incomplete archives a killed
+// process left are removed first, so no other process may be rotating the
+// file. It returns the first failure.
+func CompressPending(opts Options) error {
+	opts.CompressWindow = Window{}
+	a := newIdle(opts)
+	for _, filePath := range a.idlePaths() {
+		a.setFilePath(filePath)
+		a.removeTemporaryFiles(filePath)
+		a.processPending(filePath)
+	}
+	return a.firstError()
+}
+
+// ArchivedFiles returns the paths of the files in dir that have archives
+// there, whether the files still exist or not, sorted. With numbered also
+// the numbered archives of Options.Numbered count.
+func ArchivedFiles(dir string, numbered bool) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, info := range infos {
+		name := strings.TrimSuffix(info.Name(), tmpSuffix)
+		match := archiveSuffix.FindStringIndex(name)
+		if match == nil && numbered {
+			match = numberedSuffix.FindStringIndex(name)
+		}
+		if match != nil && match[0] > 0 {
+			seen[path.Join(dir, name[:match[0]])] = true
+		}
+	}
+	files := make([]string, 0, len(seen))
+	for file := range seen {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,101 @@
+package rotate
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// CopyTruncate archives a copy of the file and truncates it instead of
+// renaming it, for files another program keeps open and appends to, like
+// logrotate's copytruncate. Lines written between copying and truncating
+// are lost, and the other program has to open the file with O_APPEND to
+// continue at its new end. Nothing happens if the file is empty.
+func (a *Appender) CopyTruncate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrClosed
+	}
+	start := time.Now()
+	archiveName := a.archiveFileName()
+	size, err := copyFile(a.filePath, archiveName)
+	if err != nil || size == 0 {
+		os.Remove(archiveName)
+		return err
+	}
+	if err := os.Truncate(a.filePath, 0); err != nil {
+		return err
+	}
+	a.bytesWritten = 0
+	a.queueArchive(archiveJob{path: a.filePath, archive: archiveName, size: size})
+
+	duration := time.Since(start)
+	a.metrics.Add("rotations", 1)
+	a.metrics.Time("rotate", duration)
+	a.log(LevelDebug, "rotate", Fields{"file": a.filePath, "archive": archiveName, "size": size, "duration_ms": durationMillis(duration), "copytruncate": true},
+		"copied", a.filePath, "at", size, "bytes to", archiveName, "and truncated it")
+	if !a.opts.Numbered {
+		a.announceRotation(a.filePath, archiveName, size)
+	}
+	return nil
+}
+
+// truncateToArchive rotates the open file by copying it to
+// archiveName and truncating it. A hard link to the archive would share the
+// data with the file and be truncated along with it. As the lines are
+// written by the Appender, none are lost between copying and truncating.
+func (a *Appender) truncateToArchive(archiveName string) error {
+	if err := a.writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := copyFile(a.filePath, archiveName); err != nil {
+		os.Remove(archiveName)
+		return err
+	}
+	if err := a.file.Truncate(0); err != nil {
+		os.Remove(archiveName)
+		return err
+	}
+	a.bytesWritten = 0
+	a.fileLines = 0
+	a.fileStarted = a.opts.Clock()
+	a.preallocate()
+	return nil
+}
+
+// Size returns the size of the file on disk, including what other programs
+// appended to it.
+func (a *Appender) Size() (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st, err := os.Stat(a.filePath)
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+// copyFile copies src to the new file dst and syncs it.
+func copyFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return size, err
+}
@@ -0,0 +1,134 @@
+package rotate
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+const (
+	// directAlign is the alignment of the memory, offsets and lengths of
+	// direct writes, enough for all common block devices.
+	directAlign = 4096
+	// directBufferSize is how much is collected before it is written.
+	directBufferSize = 1 << 20
+)
+
+// directWriter writes to a file opened with O_DIRECT, bypassing the page
+// cache. Direct writes have to be aligned, so it collects what is written
+// in an aligned buffer and writes full blocks. The last partial block stays
+// in the buffer and, on finish, is written padded with zeros before the
+// file is truncated to its actual size, to be written again once more
+// follows.
+type directWriter struct {
+	file *os.File
+	buf  []byte
+	n    int
+	// offset is where buf starts in the file, always aligned.
+	offset int64
+}
+
+// newDirectWriter writes to the end of f, which was opened without
+// O_APPEND as direct writes need explicit offsets.
+func newDirectWriter(f *os.File) (*directWriter, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	w := &directWriter{file: f, buf: alignedBuffer(directBufferSize)}
+	w.offset = st.Size() &^ (directAlign - 1)
+	if tail := int(st.Size() - w.offset); tail > 0 {
+		// Take over the existing partial block, the direct descriptor
+		// cannot read it.
+		r, err := os.Open(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.ReadAt(w.buf[:tail], w.offset)
+		r.Close()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		w.n = tail
+	}
+	return w, nil
+}
+
+// alignedBuffer returns size bytes starting at an address aligned to
+// directAlign.
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlign)
+	skip := directAlign - int(uintptr(unsafe.Pointer(&b[0]))&(directAlign-1))
+	return b[skip : skip+size : skip+size]
+}
+
+// Write buffers p, writing the buffer whenever it is full. It returns how
+// much of p was buffered.
+func (w *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.n == len(w.buf) {
+			if err := w.writeBlocks(); err != nil {
+				return written, err
+			}
+		}
+		c := copy(w.buf[w.n:], p)
+		w.n += c
+		written += c
+		p = p[c:]
+	}
+	return written, nil
+}
+
+// writeBlocks writes the full blocks of the buffer and keeps the rest.
+func (w *directWriter) writeBlocks() error {
+	full := w.n &^ (directAlign - 1)
+	if full == 0 {
+		return nil
+	}
+	if _, err := w.file.WriteAt(w.buf[:full], w.offset); err != nil {
+		return err
+	}
+	w.n = copy(w.buf, w.buf[full:w.n])
+	w.offset += int64(full)
+	return nil
+}
+
+// finish writes everything buffered, so the file holds all lines.
+func (w *directWriter) finish() error {
+	if err := w.writeBlocks(); err != nil {
+		return err
+	}
+	if w.n == 0 {
+		return nil
+	}
+	padded := (w.n + directAlign - 1) &^ (directAlign - 1)
+	for i := w.n; i < padded; i++ {
+		w.buf[i] = 0
+	}
+	if _, err := w.file.WriteAt(w.buf[:padded], w.offset); err != nil {
+		return err
+	}
+	return w.file.Truncate(w.offset + int64(w.n))
+}
+
+// openDirect opens the file with O_DIRECT for Options.DirectIO. It falls
+// back to the page cache if the platform or filesystem does not support
+// it, e.g. tmpfs, reporting false.
+func (a *Appender) openDirect(flags int) (*os.File, bool, error) {
+	if oDirect != 0 {
+		f, err := os.OpenFile(a.filePath, flags&^os.O_APPEND|oDirect, 0644)
+		if err == nil {
+			return f, true, nil
+		}
+		if !isDirectUnsupported(err) {
+			return nil, false, err
+		}
+		a.log(LevelWarn, "direct_unsupported", Fields{"file": a.filePath, "error": err.Error()},
+			"cannot open", a.filePath, "for direct I/O, using the page cache:", err)
+	} else {
+		a.log(LevelWarn, "direct_unsupported", Fields{"file": a.filePath}, "direct I/O is only supported on Linux, using the page cache")
+	}
+	f, err := os.OpenFile(a.filePath, flags, 0644)
+	return f, false, err
+}
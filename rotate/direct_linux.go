@@ -0,0 +1,12 @@
+package rotate
+
+import (
+	"errors"
+	"syscall"
+)
+
+const oDirect = syscall.O_DIRECT
+
+func isDirectUnsupported(err error) bool {
+	return errors.Is(err, syscall.EINVAL)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package rotate
+
+const oDirect = 0
+
+func isDirectUnsupported(err error) bool {
+	return true
+}
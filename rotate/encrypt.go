@@ -0,0 +1,60 @@
+package rotate
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// encryptFile encrypts fileName for the given recipients and removes the
+// plaintext afterwards, returning the name of the encrypted file. The
+// encrypted file is written to a tmpSuffix file first and renamed when
+// complete.
+func encryptFile(fileName string, recipients []string) (string, error) {
+	binary, ext, args := encryptCommand(recipients)
+	outName := fileName + ext
+	args = append(args, "--output", outName+tmpSuffix, fileName)
+	cmd := exec.Command(binary, args...)
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err == nil {
+		err = syncFile(outName + tmpSuffix)
+	}
+	if err == nil {
+		err = os.Rename(outName+tmpSuffix, outName)
+	}
+	if err != nil {
+		os.Remove(outName + tmpSuffix)
+		return "", err
+	}
+
+	return outName, os.Remove(fileName)
+}
+
+// mixedRecipients reports whether recipients holds both age public keys and
+// GPG key ids, which cannot be encrypted for by one command.
+func mixedRecipients(recipients []string) bool {
+	for _, r := range recipients {
+		if strings.HasPrefix(r, "age1") != strings.HasPrefix(recipients[0], "age1") {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptCommand returns the binary and arguments encrypting for the given
+// recipients and the extension of its output. Recipients starting with
+// "age1" are age X25519 public keys and are handled by the age binary,
+// anything else is passed to gpg as an OpenPGP key id, fingerprint or user
+// id. Without further arguments the command encrypts stdin to stdout.
+func encryptCommand(recipients []string) (binary, ext string, args []string) {
+	if strings.HasPrefix(recipients[0], "age1") {
+		binary, ext, args = "age", ".age", []string{"--encrypt"}
+	} else {
+		binary, ext, args = "gpg", ".gpg", []string{"--batch", "--yes", "--trust-model", "always", "--encrypt"}
+	}
+	for _, r := range recipients {
+		args = append(args, "--recipient", r)
+	}
+	return binary, ext, args
+}
@@ -0,0 +1,43 @@
+package rotate
+
+// errorQueueSize is how many background errors are kept for Errors until
+// they are dropped.
+const errorQueueSize = 16
+
+// Error is a failure while processing archives in the background, e.g. when
+// compressing an archive or removing an old one.
+type Error struct {
+	// Event names the failed step like "compress_failed".
+	Event string
+	// File is the file the step failed on.
+	File string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Event + " " + e.File + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors returns the channel background failures are sent to. The Appender
+// keeps working after them; errors are dropped if nobody reads them. The
+// channel is closed by Close.
+func (a *Appender) Errors() <-chan error {
+	return a.errors
+}
+
+// fail logs err like logFailure and sends it to Errors.
+func (a *Appender) fail(event, fileName string, err error, v ...interface{}) {
+	if a.opts.Logger != nil {
+		a.opts.Logger.Log(LevelError, event, failureFields(fileName, err), failureMessage(err, v))
+	}
+
+	select {
+	case a.errors <- &Error{Event: event, File: fileName, Err: err}:
+	default:
+	}
+}
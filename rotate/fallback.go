@@ -0,0 +1,100 @@
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// DefaultFallbackRetry is how often the primary path is checked while
+// writing to the fallback.
+const DefaultFallbackRetry = 30 * time.Second
+
+const markerTimeFormat = time.RFC3339Nano
+
+// switchToFallback continues on Options.FallbackPath after writing to the
+// primary path failed with cause.
+func (a *Appender) switchToFallback(cause error) error {
+	// Drop what the failed write left in the buffer.
+	a.writer.Reset(fileWriter{a})
+	if a.encryptor != nil {
+		a.encryptor.Close()
+	}
+	a.file.Close()
+
+	primary := a.filePath
+	a.setFilePath(a.opts.FallbackPath)
+	if err := a.openFile(); err != nil {
+		a.setFilePath(primary)
+		a.openFile()
+		return err
+	}
+
+	now := time.Now()
+	a.fallbackSince, a.primaryChecked = now, now
+	a.writeMarker(fmt.Sprintf("stdin-rotate: cannot write to %s since %s, continuing here: %v",
+		primary, now.Format(markerTimeFormat), cause))
+	a.metrics.Add("fallback_switches", 1)
+	a.log(LevelWarn, "fallback", Fields{"file": primary, "fallback": a.filePath, "error": cause.Error()},
+		"cannot write to", primary, "switching to", a.filePath, "after:", cause)
+	return nil
+}
+
+// returnFromFallback switches back to the primary path if it can be written
+// to again, leaving a marker there with the time range of the gap.
+func (a *Appender) returnFromFallback() {
+	a.primaryChecked = time.Now()
+
+	f, err := os.OpenFile(a.opts.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	marker := fmt.Sprintf("stdin-rotate: lines from %s to %s were written to %s\n",
+		a.fallbackSince.Format(markerTimeFormat), time.Now().Format(markerTimeFormat), a.filePath)
+	if !endsWithNewline(a.opts.Path) {
+		// Terminate the line the failed write left incomplete.
+		marker = "\n" + marker
+	}
+	_, err = f.WriteString(marker)
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	a.closeFile()
+	fallback := a.filePath
+	a.setFilePath(a.opts.Path)
+	if err := a.openFile(); err != nil {
+		a.setFilePath(fallback)
+		a.openFile()
+		return
+	}
+
+	a.log(LevelInfo, "fallback_end", Fields{"file": a.filePath, "fallback": fallback, "since": a.fallbackSince.Format(markerTimeFormat)},
+		"writing to", a.filePath, "again after using", fallback, "since", a.fallbackSince.Format(markerTimeFormat))
+	a.fallbackSince = time.Time{}
+}
+
+// endsWithNewline reports whether the file is empty or ends with a newline.
+func endsWithNewline(fileName string) bool {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil || st.Size() == 0 {
+		return true
+	}
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, st.Size()-1); err != nil {
+		return true
+	}
+	return b[0] == '\n'
+}
+
+func (a *Appender) writeMarker(marker string) {
+	a.writer.WriteString(marker + "\n")
+	a.writer.Flush()
+}
@@ -0,0 +1,127 @@
+package rotate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// liveEncryptor encrypts what is written to the file with Options.EncryptLive
+// by piping it through age or gpg, gzipped first with Options.Compress, so
+// no plaintext reaches the disk.
+type liveEncryptor struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	gzip  *gzip.Writer
+	w     io.Writer
+}
+
+// startLiveEncryptor starts encrypting for recipients to f.
+func startLiveEncryptor(f *os.File, recipients []string, compress bool) (*liveEncryptor, error) {
+	binary, _, args := encryptCommand(recipients)
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	e := &liveEncryptor{cmd: cmd, stdin: stdin, w: stdin}
+	if compress {
+		e.gzip = gzip.NewWriter(stdin)
+		e.w = e.gzip
+	}
+	return e, nil
+}
+
+func (e *liveEncryptor) Write(p []byte) (int, error) {
+	return e.w.Write(p)
+}
+
+// Flush hands what was written so far to the encrypting process. It keeps
+// up to a chunk of its input, 64 KiB for age, until it has more or is
+// closed.
+func (e *liveEncryptor) Flush() error {
+	if e.gzip != nil {
+		return e.gzip.Flush()
+	}
+	return nil
+}
+
+// Close ends the encrypted stream and waits for the process to write it.
+func (e *liveEncryptor) Close() error {
+	var err error
+	if e.gzip != nil {
+		err = e.gzip.Close()
+	}
+	if closeErr := e.stdin.Close(); err == nil {
+		err = closeErr
+	}
+	if waitErr := e.cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
+// liveExt returns the extension of the archives of the encrypted file.
+func (a *Appender) liveExt() string {
+	_, ext, _ := encryptCommand(a.opts.EncryptRecipients)
+	if a.opts.Compress {
+		ext = ".gz" + ext
+	}
+	return ext
+}
+
+// encryptedExt returns the extension of the encryption fileName starts
+// with, age or OpenPGP, or "" if it does not look encrypted.
+func encryptedExt(fileName string) string {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	header := make([]byte, len(ageHeader))
+	n, _ := io.ReadFull(f, header)
+	switch {
+	case bytes.Equal(header[:n], ageHeader):
+		return ".age"
+	// The packet of the encrypted session key in the old and new format,
+	// never the first byte of a text line as it is not valid UTF-8.
+	case n > 0 && (header[0] == 0x84 || header[0] == 0x85 || header[0] == 0xc1):
+		return ".gpg"
+	}
+	return ""
+}
+
+var ageHeader = []byte("age-encryption.org/v1")
+
+// archiveLeftover archives what a previous process left in the file at
+// startup with Options.EncryptLive, as encrypted streams cannot be appended
+// to. A plaintext file, e.g. from before EncryptLive was enabled, is
+// archived to be encrypted like rotated ones without it.
+func (a *Appender) archiveLeftover() error {
+	info, err := os.Stat(a.filePath)
+	if err != nil || info.Size() == 0 {
+		return nil
+	}
+
+	archiveName := a.archiveFileName()
+	if ext := encryptedExt(a.filePath); ext != "" {
+		if a.opts.Compress {
+			ext = ".gz" + ext
+		}
+		archiveName += ext
+	}
+	if err := os.Rename(a.filePath, archiveName); err != nil {
+		return err
+	}
+	a.queueArchive(archiveJob{path: a.filePath, archive: archiveName})
+	return nil
+}
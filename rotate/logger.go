@@ -0,0 +1,50 @@
+package rotate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a message passed to a Logger.
+type Level int
+
+// Levels in increasing severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Fields carries the structured context of a logged event, e.g. the file it
+// is about and its size.
+type Fields map[string]interface{}
+
+// Logger receives the diagnostics of an Appender. The event is a short
+// machine readable name like "rotate" or "compress_failed".
+type Logger interface {
+	Log(level Level, event string, fields Fields, msg string)
+}
+
+func (a *Appender) log(level Level, event string, fields Fields, v ...interface{}) {
+	if a.opts.Logger == nil {
+		return
+	}
+	a.opts.Logger.Log(level, event, fields, strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+// logFailure logs err as an error event about fileName. Like fail, it calls
+// the Logger directly, so loggers see the same call depth for all of them.
+func (a *Appender) logFailure(event, fileName string, err error, v ...interface{}) {
+	if a.opts.Logger != nil {
+		a.opts.Logger.Log(LevelError, event, failureFields(fileName, err), failureMessage(err, v))
+	}
+}
+
+func failureFields(fileName string, err error) Fields {
+	return Fields{"file": fileName, "error": err.Error()}
+}
+
+func failureMessage(err error, v []interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(append(v, err)...), "\n")
+}
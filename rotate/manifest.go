@@ -0,0 +1,154 @@
+package rotate
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// manifestSuffix is appended to the path of the file for its manifest.
+const manifestSuffix = ".manifest.json"
+
+// Manifest lists the archives of a file for the tools shipping them. It is
+// written next to the file with Options.Manifest.
+type Manifest struct {
+	File     string          `json:"file"`
+	Updated  time.Time       `json:"updated"`
+	Archives []ManifestEntry `json:"archives"`
+}
+
+// ManifestEntry describes an archive holding the lines written after the
+// previous archive was rotated until it was rotated itself. Lines and Size
+// are left out if they are unknown, as for archives that were already
+// encrypted when they were first listed.
+type ManifestEntry struct {
+	Name       string     `json:"name"`
+	From       *time.Time `json:"from,omitempty"`
+	To         time.Time  `json:"to"`
+	Lines      int64      `json:"lines,omitempty"`
+	Size       int64      `json:"size,omitempty"`
+	StoredSize int64      `json:"stored_size"`
+	SHA256     string     `json:"sha256"`
+}
+
+// archiveKey returns the name of an archive without the extensions added by
+// compression and encryption, which stays the same while it is processed.
+func archiveKey(name string) string {
+	for _, ext := range []string{".age", ".gpg", ".gz", ".zst"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// updateManifest writes the manifest of filePath for its archives as they
+// are now. What is known from the previous manifest is kept, the lines and
+// checksums of new or changed archives are computed.
+func (a *Appender) updateManifest(filePath string) {
+	manifestPath := filePath + manifestSuffix
+	known := map[string]ManifestEntry{}
+	if content, err := ioutil.ReadFile(manifestPath); err == nil {
+		var old Manifest
+		if err := json.Unmarshal(content, &old); err != nil {
+			a.log(LevelWarn, "manifest_invalid", Fields{"file": manifestPath, "error": err.Error()}, "rewriting invalid manifest", manifestPath+":", err)
+		}
+		for _, entry := range old.Archives {
+			known[archiveKey(entry.Name)] = entry
+		}
+	}
+
+	archives, err := listArchives(filePath, a.opts.Numbered)
+	if err != nil {
+		a.fail("manifest_failed", manifestPath, err, "cannot list archives:")
+		return
+	}
+	m := Manifest{File: path.Base(filePath), Updated: time.Now(), Archives: []ManifestEntry{}}
+	var from *time.Time
+	for _, name := range archives {
+		fileName := path.Join(path.Dir(filePath), name)
+		info, err := os.Stat(fileName)
+		if err != nil {
+			continue
+		}
+
+		entry, ok := known[archiveKey(name)]
+		if !ok || entry.Name != name || entry.StoredSize != info.Size() {
+			entry.Name = name
+			entry.StoredSize = info.Size()
+			if entry.SHA256, err = fileChecksum(fileName); err != nil {
+				a.fail("manifest_failed", fileName, err, "cannot checksum archive:")
+				continue
+			}
+		}
+		if entry.Lines == 0 {
+			entry.Lines, entry.Size, _ = countLines(fileName)
+		}
+		entry.To, _ = ArchiveTime(name)
+		if from != nil {
+			entry.From = from
+		}
+		to := entry.To
+		from = &to
+		m.Archives = append(m.Archives, entry)
+	}
+
+	if err := writeJSONFile(manifestPath, &m); err != nil {
+		a.fail("manifest_failed", manifestPath, err, "cannot write manifest:")
+	}
+}
+
+// writeJSONFile replaces fileName with v encoded as JSON, so readers never
+// see a partial file.
+func writeJSONFile(fileName string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpName := fileName + tmpSuffix
+	err = ioutil.WriteFile(tmpName, append(content, '\n'), 0644)
+	if err == nil {
+		err = syncFile(tmpName)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// countLines returns the number of lines in an archive and its uncompressed
+// size.
+func countLines(fileName string) (lines, size int64, err error) {
+	r, err := OpenArchive(fileName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	reader := bufio.NewReader(r)
+	partial := false
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += int64(len(chunk))
+		switch err {
+		case nil:
+			lines++
+			partial = false
+		case bufio.ErrBufferFull:
+			partial = true
+		case io.EOF:
+			if partial || len(chunk) > 0 {
+				lines++
+			}
+			return lines, size, nil
+		default:
+			return 0, 0, err
+		}
+	}
+}
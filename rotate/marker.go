@@ -0,0 +1,87 @@
+package rotate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+)
+
+// rotationEvent is written to Options.RotationMarker and
+// Options.RotationFIFO when the file was rotated.
+type rotationEvent struct {
+	Event   string `json:"event"`
+	Time    string `json:"time"`
+	File    string `json:"file"`
+	Archive string `json:"archive"`
+	Size    int64  `json:"size"`
+}
+
+// createRotationFIFO creates Options.RotationFIFO if it does not exist.
+func (a *Appender) createRotationFIFO() error {
+	info, err := os.Stat(a.opts.RotationFIFO)
+	if os.IsNotExist(err) {
+		return syscall.Mkfifo(a.opts.RotationFIFO, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("%s is not a named pipe", a.opts.RotationFIFO)
+	}
+	return nil
+}
+
+// announceRotation tells programs watching Options.RotationMarker with
+// inotify or reading Options.RotationFIFO that filePath was rotated to
+// archiveName, so they do not need to poll the directory.
+func (a *Appender) announceRotation(filePath, archiveName string, size int64) {
+	if a.opts.RotationMarker == "" && a.opts.RotationFIFO == "" {
+		return
+	}
+	line, err := json.Marshal(rotationEvent{
+		Event:   "rotate",
+		Time:    a.opts.Clock().Format(time.RFC3339Nano),
+		File:    filePath,
+		Archive: archiveName,
+		Size:    size,
+	})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	if a.opts.RotationMarker != "" {
+		// Written in place, as replacing it would end inotify watches on it.
+		if err := ioutil.WriteFile(a.opts.RotationMarker, line, 0644); err != nil {
+			a.fail("marker_failed", a.opts.RotationMarker, err, "cannot update rotation marker:")
+		}
+	}
+	if a.opts.RotationFIFO != "" {
+		a.writeRotationFIFO(line)
+	}
+}
+
+// writeRotationFIFO writes line to Options.RotationFIFO without waiting, so
+// a missing or slow reader does not hold up writing. The event is dropped
+// then. The pipe is kept open while it is read, so the reader does not see
+// the end of it after every event.
+func (a *Appender) writeRotationFIFO(line []byte) {
+	var err error
+	if a.fifo == nil {
+		a.fifo, err = os.OpenFile(a.opts.RotationFIFO, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	}
+	if err == nil {
+		if _, err = a.fifo.Write(line); err != nil {
+			a.fifo.Close()
+			a.fifo = nil
+		}
+	}
+	if err != nil {
+		a.metrics.Add("rotation_events_dropped", 1)
+		a.log(LevelDebug, "rotation_event_dropped", Fields{"file": a.opts.RotationFIFO, "error": err.Error()},
+			"cannot write rotation event to", a.opts.RotationFIFO+":", err)
+	}
+}
@@ -0,0 +1,60 @@
+package rotate
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// metadataSuffix is appended to the name of an archive, without the
+// extensions of compression and encryption, for its metadata file.
+const metadataSuffix = ".meta.json"
+
+// Metadata describes an archive in the file written next to it with
+// Options.Metadata, so it stays self-describing when copied away from the
+// manifest. Archive is its name as rotated, before compression and
+// encryption. From is left out if the file already had lines when the
+// Appender opened it.
+type Metadata struct {
+	Archive string     `json:"archive"`
+	File    string     `json:"file"`
+	Host    string     `json:"host"`
+	From    *time.Time `json:"from,omitempty"`
+	To      time.Time  `json:"to"`
+	Lines   int64      `json:"lines"`
+	Size    int64      `json:"size"`
+}
+
+// metadataFileName returns the name of the metadata file of the archive
+// fileName, which stays the same while the archive is processed.
+func metadataFileName(fileName string) string {
+	return archiveKey(fileName) + metadataSuffix
+}
+
+// writeMetadata writes the metadata file of a freshly rotated archive.
+func (a *Appender) writeMetadata(job archiveJob) {
+	m := Metadata{Archive: path.Base(job.archive), File: path.Base(job.path)}
+	m.Host, _ = os.Hostname()
+	if !job.started.IsZero() {
+		m.From = &job.started
+	}
+	m.To, _ = ArchiveTime(job.archive)
+	switch path.Ext(job.archive) {
+	case ".age", ".gpg":
+		// Encrypted while it was written, it can only be described by what
+		// was counted then.
+		if job.size == 0 {
+			return
+		}
+		m.Lines, m.Size = job.lines, job.size
+	default:
+		var err error
+		if m.Lines, m.Size, err = countLines(job.archive); err != nil {
+			a.fail("metadata_failed", job.archive, err, "cannot count lines:")
+			return
+		}
+	}
+	if err := writeJSONFile(metadataFileName(job.archive), &m); err != nil {
+		a.fail("metadata_failed", job.archive, err, "cannot write metadata file:")
+	}
+}
@@ -0,0 +1,62 @@
+package rotate
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics keeps cumulative counters and timings of the Appender's activity.
+// It is safe for concurrent use; reporters take snapshots and compute the
+// deltas they need themselves.
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	timings  map[string]Timing
+}
+
+// Timing is the number of timed events and their total duration.
+type Timing struct {
+	Count int64
+	Total time.Duration
+}
+
+// NewMetrics returns empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters: make(map[string]int64),
+		timings:  make(map[string]Timing),
+	}
+}
+
+// Add increments the counter name by delta.
+func (m *Metrics) Add(name string, delta int64) {
+	m.mu.Lock()
+	m.counters[name] += delta
+	m.mu.Unlock()
+}
+
+// Time records one event of name that took d.
+func (m *Metrics) Time(name string, d time.Duration) {
+	m.mu.Lock()
+	t := m.timings[name]
+	t.Count++
+	t.Total += d
+	m.timings[name] = t
+	m.mu.Unlock()
+}
+
+// Snapshot returns copies of the current counters and timings.
+func (m *Metrics) Snapshot() (map[string]int64, map[string]Timing) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := make(map[string]int64, len(m.counters))
+	for name, v := range m.counters {
+		counters[name] = v
+	}
+	timings := make(map[string]Timing, len(m.timings))
+	for name, t := range m.timings {
+		timings[name] = t
+	}
+	return counters, timings
+}
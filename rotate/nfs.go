@@ -0,0 +1,50 @@
+package rotate
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// staleRetries is how often an operation failing with ESTALE is retried with
+// NFSSafe, staleRetryDelay apart. The file handle goes stale when the server
+// lost track of the file, e.g. after it restarted, and lookup starts over.
+const (
+	staleRetries    = 3
+	staleRetryDelay = 100 * time.Millisecond
+)
+
+// retryStale runs op again while it fails with ESTALE, if the Appender is
+// NFSSafe.
+func (a *Appender) retryStale(op func() error) error {
+	err := op()
+	for i := 0; i < staleRetries && a.opts.NFSSafe && errors.Is(err, syscall.ESTALE); i++ {
+		a.metrics.Add("nfs.stale_retries", 1)
+		time.Sleep(staleRetryDelay)
+		err = op()
+	}
+	return err
+}
+
+// copyToArchive rotates the closed file by copying it to archiveName,
+// syncing the copy and removing the file afterwards, so a rename the server
+// applied twice or not at all cannot leave an empty or duplicated archive.
+// The file is kept if it cannot be copied.
+func (a *Appender) copyToArchive(archiveName string) error {
+	err := a.retryStale(func() error {
+		os.Remove(archiveName)
+		_, err := copyFile(a.filePath, archiveName)
+		return err
+	})
+	if err != nil {
+		os.Remove(archiveName)
+		return err
+	}
+	return a.retryStale(func() error {
+		if err := os.Remove(a.filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
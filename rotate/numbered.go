@@ -0,0 +1,94 @@
+package rotate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+// numberedSuffixPattern matches what Options.Numbered appends to the file
+// name, the number like logrotate's, followed by the extensions of
+// compression and encryption.
+const numberedSuffixPattern = `\.(\d+)(\.gz)?(\.age|\.gpg)?$`
+
+var numberedSuffix = regexp.MustCompile(numberedSuffixPattern)
+
+// numberedPattern matches the names of the numbered archives of baseName.
+func numberedPattern(baseName string) *regexp.Regexp {
+	return regexp.MustCompile("^" + regexp.QuoteMeta(baseName) + numberedSuffixPattern)
+}
+
+// archiveNumber returns the number in the name of a numbered archive. It
+// reports false if the archive is named by its time.
+func archiveNumber(name string) (int, bool) {
+	if archiveSuffix.MatchString(path.Base(name)) {
+		return 0, false
+	}
+	match := numberedSuffix.FindStringSubmatch(path.Base(name))
+	if match == nil {
+		return 0, false
+	}
+	number, err := strconv.Atoi(match[1])
+	return number, err == nil
+}
+
+// numberArchives renames the archives of filePath still named by their
+// time to filePath.1 with Options.Numbered, oldest first up to and including
+// upTo or all of them if it is empty, renaming the numbered ones to the next
+// higher number first like logrotate does. Rotation names the archive by its
+// time as usual, so the renaming happens here in the background along with
+// compressing, which would otherwise have archives renamed under it. It
+// returns the new name of upTo.
+func (a *Appender) numberArchives(filePath, upTo string) string {
+	dir := path.Dir(filePath)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		a.fail("number_failed", filePath, err, "cannot list archives:")
+		return upTo
+	}
+	var timed, numbered []string
+	timedPattern, numberPattern := archivePattern(path.Base(filePath)), numberedPattern(path.Base(filePath))
+	for _, info := range infos {
+		switch name := info.Name(); {
+		case timedPattern.MatchString(name):
+			timed = append(timed, name)
+		case numberPattern.MatchString(name):
+			numbered = append(numbered, name)
+		}
+	}
+	sortArchives(timed)
+
+	for _, name := range timed {
+		// The highest number first, so none is overwritten.
+		sortArchives(numbered)
+		shifted := make([]string, 0, len(numbered)+1)
+		for _, old := range numbered {
+			number, _ := archiveNumber(old)
+			match := numberedSuffix.FindStringSubmatch(old)
+			renamed := fmt.Sprintf("%s.%d%s%s", path.Base(filePath), number+1, match[2], match[3])
+			if err := os.Rename(path.Join(dir, old), path.Join(dir, renamed)); err != nil {
+				a.fail("number_failed", path.Join(dir, old), err, "cannot renumber archive:")
+				return upTo
+			}
+			shifted = append(shifted, renamed)
+		}
+
+		match := archiveSuffix.FindStringSubmatch(name)
+		renamed := path.Base(filePath) + ".1" + match[5] + match[6]
+		if err := os.Rename(path.Join(dir, name), path.Join(dir, renamed)); err != nil {
+			a.fail("number_failed", path.Join(dir, name), err, "cannot number archive:")
+			return upTo
+		}
+		a.log(LevelDebug, "number", Fields{"file": path.Join(dir, name), "archive": path.Join(dir, renamed)},
+			"renamed", path.Join(dir, name), "to", path.Join(dir, renamed))
+		numbered = append(shifted, renamed)
+
+		if path.Join(dir, name) == upTo {
+			return path.Join(dir, renamed)
+		}
+	}
+	return upTo
+}
@@ -0,0 +1,75 @@
+package rotate
+
+import (
+	"context"
+	"time"
+)
+
+// Option configures an Appender created with Open.
+type Option func(*Options)
+
+// WithMaxSize sets the size in bytes at which the file is rotated.
+func WithMaxSize(maxSize int) Option {
+	return func(o *Options) { o.MaxSize = maxSize }
+}
+
+// WithCompression enables or disables gzip compression of archives.
+func WithCompression(enabled bool) Option {
+	return func(o *Options) { o.Compress = enabled }
+}
+
+// WithRetention sets the number of archives to keep, 0 to keep all.
+func WithRetention(maxFiles int) Option {
+	return func(o *Options) { o.MaxFiles = maxFiles }
+}
+
+// WithMaxAge removes the archives rotated more than maxAge ago, combined
+// with WithRetention by policy.
+func WithMaxAge(maxAge time.Duration, policy RetentionPolicy) Option {
+	return func(o *Options) { o.MaxAge, o.RetentionPolicy = maxAge, policy }
+}
+
+// WithMaxTotalSize removes the oldest archives while they take more than
+// maxTotal bytes together.
+func WithMaxTotalSize(maxTotal int64) Option {
+	return func(o *Options) { o.MaxTotalSize = maxTotal }
+}
+
+// WithClock replaces time.Now for naming archives, so tests get
+// predictable file names.
+func WithClock(now func() time.Time) Option {
+	return func(o *Options) { o.Clock = now }
+}
+
+// WithLogger sets the Logger receiving the diagnostics.
+func WithLogger(logger Logger) Option {
+	return func(o *Options) { o.Logger = logger }
+}
+
+// WithMetrics sets the Metrics to record the activity in.
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *Options) { o.Metrics = metrics }
+}
+
+// Open creates an Appender for path configured by opts. The Appender is
+// closed once ctx is done, which waits for pending archives like Close.
+func Open(ctx context.Context, path string, opts ...Option) (*Appender, error) {
+	options := Options{Path: path}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	a, err := New(options)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.Close()
+		case <-a.done:
+		}
+	}()
+	return a, nil
+}
@@ -0,0 +1,26 @@
+package rotate
+
+// preallocate reserves the disk space of the file up to the size it is
+// rotated at, with Options.Preallocate. It is only logged if the
+// filesystem does not support it, as appending works either way.
+func (a *Appender) preallocate() {
+	if !a.opts.Preallocate || a.encryptor != nil || a.bytesWritten >= a.maxSize {
+		return
+	}
+	if err := fallocate(a.file, int64(a.maxSize)); err != nil {
+		a.metrics.Add("preallocate_errors", 1)
+		a.log(LevelWarn, "preallocate_failed", Fields{"file": a.filePath, "size": a.maxSize, "error": err.Error()},
+			"cannot preallocate", a.maxSize, "bytes for", a.filePath+":", err)
+	}
+}
+
+// releasePreallocated frees the space reserved beyond the end of the file,
+// so archives do not keep it.
+func (a *Appender) releasePreallocated() {
+	if !a.opts.Preallocate || a.encryptor != nil {
+		return
+	}
+	if st, err := a.file.Stat(); err == nil {
+		a.file.Truncate(st.Size())
+	}
+}
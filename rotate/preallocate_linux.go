@@ -0,0 +1,20 @@
+package rotate
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize reserves the blocks without changing the size of the
+// file, so appending still starts at the end of the lines.
+const fallocKeepSize = 0x01
+
+// fallocate reserves size bytes of disk space for f.
+func fallocate(f *os.File, size int64) error {
+	for {
+		err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package rotate
+
+import (
+	"errors"
+	"os"
+)
+
+func fallocate(f *os.File, size int64) error {
+	return errors.New("preallocating files is only supported on Linux")
+}
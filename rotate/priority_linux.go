@@ -0,0 +1,24 @@
+package rotate
+
+import "syscall"
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowerPriority gives the calling thread the lowest CPU priority and the
+// idle I/O scheduling class, so it only gets the disk when nobody else
+// needs it. Linux sets both per thread.
+func lowerPriority() error {
+	tid := syscall.Gettid()
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, 19); err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
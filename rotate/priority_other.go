@@ -0,0 +1,9 @@
+//go:build !linux
+
+package rotate
+
+import "errors"
+
+func lowerPriority() error {
+	return errors.New("lowering the priority of a thread is only supported on Linux")
+}
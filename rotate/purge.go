@@ -0,0 +1,62 @@
+package rotate
+
+import "time"
+
+// Purge applies the retention of opts, MaxFiles, MaxAge, MaxFilesPerDay,
+// MaxTotalSize and RetentionPolicy along with VerifyChecksum and
+// RetentionDryRun, to the archives of opts.Path and opts.FallbackPath once,
+// without opening the file or waiting for a rotation. The archives of a
+// symbolic link are those next to its target. It removes what it can and
+// returns the first failure.
+func Purge(opts Options) error {
+	a := newIdle(opts)
+	for _, filePath := range a.idlePaths() {
+		a.setFilePath(filePath)
+		a.removeOldFiles(filePath)
+	}
+	return a.firstError()
+}
+
+// newIdle returns an Appender for working on the archives of opts.Path and
+// opts.FallbackPath without opening either.
+func newIdle(opts Options) *Appender {
+	if opts.Metrics == nil {
+		opts.Metrics = NewMetrics()
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	a := &Appender{
+		opts:    opts,
+		metrics: opts.Metrics,
+		errors:  make(chan error, errorQueueSize),
+	}
+	a.maxFiles.Store(int64(opts.MaxFiles))
+	return a
+}
+
+// idlePaths returns Path and FallbackPath if set, symbolic links resolved to
+// their targets.
+func (a *Appender) idlePaths() []string {
+	var paths []string
+	for _, filePath := range []string{a.opts.Path, a.opts.FallbackPath} {
+		if filePath == "" {
+			continue
+		}
+		if target, err := SymlinkTarget(filePath); err == nil {
+			filePath = target
+		}
+		paths = append(paths, filePath)
+	}
+	return paths
+}
+
+// firstError returns the first failure reported, if any.
+func (a *Appender) firstError() error {
+	select {
+	case err := <-a.errors:
+		return err
+	default:
+		return nil
+	}
+}
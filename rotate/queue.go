@@ -0,0 +1,50 @@
+package rotate
+
+// QueuePolicy decides what happens to a rotated archive when QueueSize
+// archives are already waiting to be processed.
+type QueuePolicy int
+
+const (
+	// QueueBlock waits for room in the queue, which blocks writes.
+	QueueBlock QueuePolicy = iota
+	// QueueSkip leaves the new archive unprocessed, until it is picked up
+	// at the next start.
+	QueueSkip
+	// QueueDropOldest leaves the oldest waiting archive unprocessed to make
+	// room for the new one.
+	QueueDropOldest
+)
+
+// queueArchive queues a freshly rotated archive for processing according to
+// the QueuePolicy.
+func (a *Appender) queueArchive(job archiveJob) {
+	a.wg.Add(1)
+	select {
+	case a.lastFileChan <- job:
+		return
+	default:
+	}
+
+	switch a.opts.QueuePolicy {
+	case QueueSkip:
+		a.wg.Done()
+		a.metrics.Add("queue.skipped", 1)
+		a.log(LevelWarn, "queue_skipped", Fields{"file": a.filePath, "archive": job.archive},
+			"archive queue is full, leaving", job.archive, "unprocessed")
+		return
+	case QueueDropOldest:
+		select {
+		case dropped := <-a.lastFileChan:
+			a.wg.Done()
+			a.metrics.Add("queue.dropped", 1)
+			a.log(LevelWarn, "queue_dropped", Fields{"file": a.filePath, "archive": dropped.archive},
+				"archive queue is full, leaving", dropped.archive, "unprocessed")
+		default:
+		}
+	default:
+		a.metrics.Add("queue.blocked", 1)
+		a.log(LevelWarn, "queue_blocked", Fields{"file": a.filePath, "archive": job.archive},
+			"archive queue is full, waiting to queue", job.archive)
+	}
+	a.lastFileChan <- job
+}
@@ -0,0 +1,137 @@
+package rotate
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Archives returns the paths of the archives of the file at filePath, oldest
+// first, including the numbered ones of Options.Numbered.
+func Archives(filePath string) ([]string, error) {
+	names, err := listArchives(filePath, true)
+	if err != nil {
+		return nil, err
+	}
+	for index, name := range names {
+		names[index] = path.Join(path.Dir(filePath), name)
+	}
+	return names, nil
+}
+
+var archiveSuffix = regexp.MustCompile(archiveSuffixPattern)
+
+// ArchiveTime returns when the archive fileName was rotated according to its
+// name. It reports false if fileName is not named like an archive.
+func ArchiveTime(fileName string) (time.Time, bool) {
+	match := archiveSuffix.FindStringSubmatch(path.Base(fileName))
+	if match == nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(archiveTimeFormat, match[2])
+	return t, err == nil
+}
+
+// OpenArchive opens an archive or the live file for reading, decompressing
+// it if it is gzipped or compressed with zstd, the latter by the zstd binary.
+// Encrypted archives and live files cannot be read.
+func OpenArchive(fileName string) (io.ReadCloser, error) {
+	compressed := strings.HasSuffix(fileName, ".gz") || strings.HasSuffix(fileName, ".zst")
+	switch path.Ext(fileName) {
+	case ".age", ".gpg":
+		return nil, fmt.Errorf("rotate: cannot read encrypted archive %s", fileName)
+	}
+	if !compressed && encryptedExt(fileName) != "" {
+		return nil, fmt.Errorf("rotate: cannot read encrypted file %s", fileName)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(fileName, ".zst") {
+		return openZstd(f)
+	}
+	if !compressed {
+		return f, nil
+	}
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot decompress %s: %v", fileName, err)
+	}
+	return &gzipFile{Reader: r, file: f}, nil
+}
+
+// gzipFile closes the file along with the gzip.Reader reading it.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// zstdFile reads the output of zstd decompressing the file, as the standard
+// library has no zstd decoder.
+type zstdFile struct {
+	stdout io.ReadCloser
+	cmd    *exec.Cmd
+	file   *os.File
+	waited bool
+	err    error
+}
+
+func openZstd(f *os.File) (*zstdFile, error) {
+	cmd := exec.Command("zstd", "-d", "-c", "-q")
+	cmd.Stdin = f
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot decompress %s: %v", f.Name(), err)
+	}
+	return &zstdFile{stdout: stdout, cmd: cmd, file: f}, nil
+}
+
+// Read returns the error of zstd at the end of its output, so corrupt or
+// truncated archives do not look complete.
+func (z *zstdFile) Read(p []byte) (int, error) {
+	n, err := z.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := z.wait(); waitErr != nil {
+			err = fmt.Errorf("cannot decompress %s: %v", z.file.Name(), waitErr)
+		}
+	}
+	return n, err
+}
+
+func (z *zstdFile) wait() error {
+	if !z.waited {
+		z.waited = true
+		z.err = z.cmd.Wait()
+	}
+	return z.err
+}
+
+// Close ends zstd with SIGPIPE if not everything was read, instead of
+// leaving it blocked on the full pipe.
+func (z *zstdFile) Close() error {
+	z.stdout.Close()
+	z.wait()
+	return z.file.Close()
+}
@@ -0,0 +1,155 @@
+package rotate
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// Repack rewrites the archives of opts.Path, oldest first, into new ones as
+// opts configures, e.g. with another MaxSize, compression or naming, and
+// replaces the old ones along with their checksum and metadata files and the
+// manifest. A new archive is named by the time of rotation of the old one
+// its last lines come from, or by its modification time if it is numbered,
+// so the archives keep their order and age. Retention does not apply, and
+// neither do RotationMarker and RotationFIFO. The file itself is left
+// alone, but no other process may be rotating it meanwhile.
+//
+// The new archives are written to a temporary directory next to the file.
+// If moving them into place fails halfway, the error names the directories
+// holding the old and the new archives.
+func Repack(opts Options) error {
+	filePath := opts.Path
+	if target, err := SymlinkTarget(filePath); err == nil {
+		filePath = target
+	}
+	dir, base := path.Dir(filePath), path.Base(filePath)
+	archives, err := listArchives(filePath, true)
+	if err != nil {
+		return err
+	}
+	for _, name := range archives {
+		if ext := path.Ext(name); ext == ".age" || ext == ".gpg" {
+			return fmt.Errorf("rotate: cannot repack encrypted archive %s", path.Join(dir, name))
+		}
+	}
+	if len(archives) == 0 {
+		return nil
+	}
+
+	newDir, err := ioutil.TempDir(dir, "."+base+".repack")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(newDir)
+	if err := repackInto(opts, path.Join(newDir, base), dir, archives); err != nil {
+		return err
+	}
+
+	oldDir, err := ioutil.TempDir(dir, "."+base+".repacked")
+	if err != nil {
+		return err
+	}
+	old := []string{filePath + manifestSuffix}
+	for _, name := range archives {
+		fileName := path.Join(dir, name)
+		old = append(old, fileName, fileName+checksumSuffix, metadataFileName(fileName))
+	}
+	for _, fileName := range old {
+		err := os.Rename(fileName, path.Join(oldDir, path.Base(fileName)))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate: cannot move old archives to %s, the new ones are lost: %v", oldDir, err)
+		}
+	}
+	infos, err := ioutil.ReadDir(newDir)
+	if err != nil {
+		return fmt.Errorf("rotate: cannot list new archives, the old ones are in %s: %v", oldDir, err)
+	}
+	for _, info := range infos {
+		if info.Name() == base {
+			continue
+		}
+		if err := os.Rename(path.Join(newDir, info.Name()), path.Join(dir, info.Name())); err != nil {
+			// Keep the new archives left for moving them by hand.
+			kept := newDir + ".failed"
+			os.Rename(newDir, kept)
+			return fmt.Errorf("rotate: cannot move new archives from %s, the old ones are in %s: %v", kept, oldDir, err)
+		}
+	}
+	return os.RemoveAll(oldDir)
+}
+
+// repackInto writes the lines of the archives in dir to newPath with opts,
+// rotating it as configured and once at the end, and waits until its
+// archives are processed.
+func repackInto(opts Options, newPath, dir string, archives []string) error {
+	// The time of rotation of the archive being read, for naming the new
+	// ones.
+	var rotated int64
+	opts.Path, opts.FallbackPath = newPath, ""
+	opts.Clock = func() time.Time { return time.Unix(0, atomic.LoadInt64(&rotated)) }
+	opts.MaxFiles, opts.MaxAge, opts.MaxFilesPerDay, opts.MaxTotalSize = 0, 0, 0, 0
+	opts.RetentionDryRun, opts.PurgeOnFull = false, false
+	opts.MinFreeSpace, opts.BlockOnLowSpace = 0, false
+	opts.CompressWindow = Window{}
+	opts.RotationMarker, opts.RotationFIFO = "", ""
+	opts.RotateOnStart, opts.TruncateOnStart, opts.Shared = false, false, false
+	opts.Symlinks = SymlinkFollow
+	a, err := New(opts)
+	if err != nil {
+		return err
+	}
+	// When the lines of the oldest archive started is unknown.
+	a.mu.Lock()
+	a.fileStarted = time.Time{}
+	a.mu.Unlock()
+
+	for _, name := range archives {
+		fileName := path.Join(dir, name)
+		t, ok := ArchiveTime(name)
+		if !ok {
+			info, err := os.Stat(fileName)
+			if err != nil {
+				a.Close()
+				return err
+			}
+			t = info.ModTime()
+		}
+		atomic.StoreInt64(&rotated, t.UnixNano())
+
+		r, err := OpenArchive(fileName)
+		if err == nil {
+			_, err = io.Copy(a, r)
+			if closeErr := r.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			a.Close()
+			return fmt.Errorf("rotate: cannot repack %s: %v", fileName, err)
+		}
+	}
+
+	// A last line without a newline would only be written on Close, to the
+	// file that is thrown away.
+	a.mu.Lock()
+	partial := len(a.partial) > 0
+	a.mu.Unlock()
+	if partial {
+		a.Write([]byte{'\n'})
+	}
+	err = a.Rotate()
+	if closeErr := a.Close(); err == nil {
+		err = closeErr
+	}
+	for failure := range a.Errors() {
+		if err == nil {
+			err = failure
+		}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package rotate
+
+import (
+	"os"
+	"path"
+	"time"
+)
+
+// RetentionPolicy decides how MaxFiles and MaxAge combine if both are set.
+type RetentionPolicy int
+
+const (
+	// RetainAll keeps only the archives both limits keep, so at most
+	// MaxFiles and none older than MaxAge.
+	RetainAll RetentionPolicy = iota
+	// RetainAny keeps the archives either limit keeps, so the newest
+	// MaxFiles and all younger than MaxAge.
+	RetainAny
+)
+
+// expired reports whether the archive name, the index-th oldest of count,
+// is to be removed by the retention limits keep and maxAge, zero if unset,
+// at now.
+func (a *Appender) expired(name string, index, count, keep int, maxAge time.Duration, now time.Time) bool {
+	tooMany := keep > 0 && index < count-keep
+	tooOld := false
+	if maxAge > 0 {
+		rotated, ok := ArchiveTime(name)
+		tooOld = ok && now.Sub(rotated) > maxAge
+	}
+
+	if a.opts.RetentionPolicy == RetainAny && keep > 0 && maxAge > 0 {
+		return tooMany && tooOld
+	}
+	return tooMany || tooOld
+}
+
+// perDayExcess splits archives, oldest first, into the oldest ones of the
+// days with more than perDay archives, by the dates in their names, and the
+// others.
+func perDayExcess(archives []string, perDay int) (excess, rest []string) {
+	day := func(name string) string {
+		rotated, ok := ArchiveTime(name)
+		if !ok {
+			return ""
+		}
+		return rotated.Format("2006-01-02")
+	}
+
+	counts := map[string]int{}
+	for _, name := range archives {
+		counts[day(name)]++
+	}
+	for _, name := range archives {
+		d := day(name)
+		if d != "" && counts[d] > perDay {
+			excess = append(excess, name)
+			counts[d]--
+			continue
+		}
+		rest = append(rest, name)
+	}
+	return excess, rest
+}
+
+// totalSizeExcess returns the oldest of archives in dir, oldest first, that
+// have to go for the others to take at most maxTotal bytes.
+func totalSizeExcess(dir string, archives []string, maxTotal int64) []string {
+	var total int64
+	for index := len(archives) - 1; index >= 0; index-- {
+		info, err := os.Lstat(path.Join(dir, archives[index]))
+		if err != nil {
+			continue
+		}
+		if total += info.Size(); total > maxTotal {
+			return archives[:index+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package rotate
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sequenceSuffix is appended to Options.Path for the file keeping the last
+// sequence number used in an archive name.
+const sequenceSuffix = ".seq"
+
+// loadSequence continues after the highest sequence number found in the
+// sequence file or in the names of the archives, so it keeps increasing if
+// either of them is lost.
+func (a *Appender) loadSequence() error {
+	content, err := ioutil.ReadFile(a.opts.Path + sequenceSuffix)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if a.sequence, err = strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64); err != nil {
+			return err
+		}
+	}
+
+	archives, err := listArchives(a.opts.Path, a.opts.Numbered)
+	if err != nil {
+		return err
+	}
+	for _, name := range archives {
+		if seq, ok := archiveSequence(name); ok && seq > a.sequence {
+			a.sequence = seq
+		}
+	}
+	return nil
+}
+
+// nextSequence returns the sequence number for the next archive, saving it
+// first so it is not used again after a restart.
+func (a *Appender) nextSequence() int64 {
+	a.sequence++
+	fileName := a.opts.Path + sequenceSuffix
+	tmpName := fileName + tmpSuffix
+	err := ioutil.WriteFile(tmpName, []byte(strconv.FormatInt(a.sequence, 10)+"\n"), 0644)
+	if err == nil {
+		err = syncFile(tmpName)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		a.fail("sequence_failed", fileName, err, "cannot save sequence number:")
+	}
+	return a.sequence
+}
+
+// archiveSequence returns the sequence number in the name of an archive. It
+// reports false if the archive was named without one.
+func archiveSequence(name string) (int64, bool) {
+	match := archiveSuffix.FindStringSubmatch(path.Base(name))
+	if match == nil || match[1] == "" {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(match[1], 10, 64)
+	return seq, err == nil
+}
+
+// sortArchives sorts archive names oldest first. Numbered archives come
+// first, the highest number first, as they are older than those named by
+// their time unless Options.Numbered has yet to number them. Archives with a
+// sequence number are ordered by it, so clock steps do not change their
+// order, and come after those without one, which are ordered by their
+// timestamps, also if some are in UTC and others in local time.
+func sortArchives(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		numberI, numberedI := archiveNumber(names[i])
+		numberJ, numberedJ := archiveNumber(names[j])
+		if numberedI != numberedJ {
+			return numberedI
+		}
+		if numberedI && numberI != numberJ {
+			return numberI > numberJ
+		}
+		seqI, okI := archiveSequence(names[i])
+		seqJ, okJ := archiveSequence(names[j])
+		if okI != okJ {
+			return okJ
+		}
+		if okI && seqI != seqJ {
+			return seqI < seqJ
+		}
+		timeI, _ := ArchiveTime(names[i])
+		timeJ, _ := ArchiveTime(names[j])
+		if !timeI.Equal(timeJ) {
+			return timeI.Before(timeJ)
+		}
+		return names[i] < names[j]
+	})
+}
@@ -0,0 +1,91 @@
+package rotate
+
+import (
+	"os"
+	"syscall"
+)
+
+// sharedLockSuffix is appended to Options.Path for the file that instances
+// sharing it with Options.Shared lock around writes and rotation.
+const sharedLockSuffix = ".rotate.lock"
+
+// openSharedLock opens the lock file of Options.Shared.
+func (a *Appender) openSharedLock() error {
+	f, err := os.OpenFile(a.opts.Path+sharedLockSuffix, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	a.sharedLock = f
+	return nil
+}
+
+func (a *Appender) flockShared(how int) error {
+	for {
+		err := syscall.Flock(int(a.sharedLock.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// writeShared writes line with Options.Shared. It holds a shared lock while
+// writing, so no other instance renames the file in the middle of it, and
+// follows the file first if another instance rotated it. If it is full, the
+// first instance to get the exclusive lock rotates it and the others find
+// the new file once they get the lock.
+func (a *Appender) writeShared(line []byte) error {
+	if err := a.flockShared(syscall.LOCK_SH); err != nil {
+		return err
+	}
+	defer a.flockShared(syscall.LOCK_UN)
+
+	if err := a.followShared(); err != nil {
+		return err
+	}
+	if a.bytesWritten >= a.maxSize {
+		if err := a.rotateShared(); err != nil {
+			return err
+		}
+	}
+	return a.writeLine(line)
+}
+
+// rotateShared rotates the file with the exclusive lock unless another
+// instance rotated it while waiting for the lock. The lock is kept until the
+// caller unlocks it.
+func (a *Appender) rotateShared() error {
+	if err := a.flockShared(syscall.LOCK_EX); err != nil {
+		return err
+	}
+	if err := a.followShared(); err != nil {
+		return err
+	}
+	if a.bytesWritten < a.maxSize {
+		a.metrics.Add("shared.rotations_skipped", 1)
+		return nil
+	}
+	if a.opts.Sequence {
+		// Another instance may have used the next number.
+		if err := a.loadSequence(); err != nil {
+			return err
+		}
+	}
+	return a.rotateFile()
+}
+
+// followShared reopens the file if another instance rotated it and takes
+// over its size, which includes the lines of the other instances.
+func (a *Appender) followShared() error {
+	open, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	current, err := os.Stat(a.filePath)
+	if err != nil || !os.SameFile(open, current) {
+		a.metrics.Add("shared.reopens", 1)
+		a.closeFile()
+		return a.openFile()
+	}
+	a.bytesWritten = int(current.Size())
+	return nil
+}
@@ -0,0 +1,56 @@
+package rotate
+
+import (
+	"path"
+	"syscall"
+	"time"
+)
+
+// spaceCheckInterval is how often writes check the free space with
+// Options.MinFreeSpace, and how long they wait between the checks while
+// blocked by Options.BlockOnLowSpace.
+const spaceCheckInterval = time.Second
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// filesystem of filePath, which need not exist.
+func FreeSpace(filePath string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path.Dir(filePath), &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// checkSpace removes the oldest archives while less than MinFreeSpace is
+// free. With BlockOnLowSpace it waits if that is not enough, releasing the
+// lock, so Close can still stop it.
+func (a *Appender) checkSpace() {
+	a.spaceChecked = time.Now()
+	for {
+		free, err := FreeSpace(a.filePath)
+		if err != nil || free >= a.opts.MinFreeSpace {
+			if err == nil && a.lowSpace {
+				a.lowSpace = false
+				a.log(LevelInfo, "low_space_end", Fields{"file": a.filePath, "free": free}, "enough free space for", a.filePath, "again")
+			}
+			return
+		}
+		if a.purgeOldest("low disk space") {
+			continue
+		}
+
+		if !a.lowSpace {
+			a.lowSpace = true
+			a.metrics.Add("low_space", 1)
+			a.log(LevelError, "low_space", Fields{"file": a.filePath, "free": free, "min_free": a.opts.MinFreeSpace},
+				"only", free, "bytes free for", a.filePath, "with no archives left to remove")
+		}
+		if !a.opts.BlockOnLowSpace || a.closed {
+			return
+		}
+		a.mu.Unlock()
+		time.Sleep(spaceCheckInterval)
+		a.mu.Lock()
+		a.spaceChecked = time.Now()
+	}
+}
@@ -0,0 +1,78 @@
+package rotate
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// SymlinkPolicy decides what happens if Options.Path is a symbolic link.
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow appends to the target of the link and rotates it next
+	// to the target, leaving the link as it is. The link is resolved once
+	// when the Appender is created.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkReplace removes the link and appends to a regular file in its
+	// place.
+	SymlinkReplace
+)
+
+// SymlinkTarget returns the file the symbolic link fileName leads to, even
+// if it does not exist yet, or fileName itself if it is no link.
+func SymlinkTarget(fileName string) (string, error) {
+	info, err := os.Lstat(fileName)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return fileName, nil
+	}
+	if target, err := filepath.EvalSymlinks(fileName); err == nil {
+		return target, nil
+	}
+
+	// A dangling link, create its target.
+	target, err := os.Readlink(fileName)
+	if err != nil {
+		return "", err
+	}
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(fileName), target)
+	}
+	return target, nil
+}
+
+// resolveSymlinks applies Options.Symlinks to Path and FallbackPath.
+func (a *Appender) resolveSymlinks() error {
+	var err error
+	if a.opts.Path, err = a.resolveSymlink(a.opts.Path); err != nil {
+		return err
+	}
+	a.setFilePath(a.opts.Path)
+	if a.opts.FallbackPath != "" {
+		a.opts.FallbackPath, err = a.resolveSymlink(a.opts.FallbackPath)
+	}
+	return err
+}
+
+// resolveSymlink applies Options.Symlinks to the file at filePath and
+// returns the path to append to.
+func (a *Appender) resolveSymlink(filePath string) (string, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return filePath, nil
+	}
+
+	if a.opts.Symlinks == SymlinkReplace {
+		if err := os.Remove(filePath); err != nil {
+			return "", err
+		}
+		a.log(LevelInfo, "symlink_replaced", Fields{"file": filePath}, "replaced symbolic link", filePath, "with a regular file")
+		return filePath, nil
+	}
+	target, err := SymlinkTarget(filePath)
+	if err != nil {
+		return "", err
+	}
+	a.log(LevelInfo, "symlink_followed", Fields{"file": filePath, "target": target}, "writing to", target, "which", filePath, "links to")
+	return target, nil
+}
@@ -0,0 +1,34 @@
+package rotate
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader limits reading to rate bytes per second, so compressing
+// large archives does not starve the disk for the writers of the file.
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func newThrottledReader(r io.Reader, rate int64) *throttledReader {
+	return &throttledReader{r: r, rate: rate, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read at most a tenth of a second's worth at once to avoid bursts.
+	if limit := t.rate / 10; limit > 0 && int64(len(p)) > limit {
+		p = p[:limit]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+
+	due := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,101 @@
+package rotate
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window is a daily time range in local time, e.g. 01:00-05:00. It wraps
+// around midnight if End is before Start. The zero Window is always open.
+type Window struct {
+	// Start and End are the offsets from midnight.
+	Start, End time.Duration
+}
+
+// ParseWindow parses a window like 01:00-05:00 or 22:00-04:00.
+func ParseWindow(value string) (Window, error) {
+	var startHour, startMinute, endHour, endMinute int
+	if _, err := fmt.Sscanf(value, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute); err != nil {
+		return Window{}, fmt.Errorf("window %q is not like 01:00-05:00", value)
+	}
+	for _, hm := range [][2]int{{startHour, startMinute}, {endHour, endMinute}} {
+		if hm[0] < 0 || hm[0] > 24 || hm[1] < 0 || hm[1] > 59 || hm[0] == 24 && hm[1] != 0 {
+			return Window{}, fmt.Errorf("window %q has an invalid time", value)
+		}
+	}
+	w := Window{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute,
+	}
+	if w.Start == w.End {
+		return Window{}, fmt.Errorf("window %q is empty", value)
+	}
+	return w, nil
+}
+
+// IsZero reports whether w is the zero Window, which is always open.
+func (w Window) IsZero() bool {
+	return w.Start == 0 && w.End == 0
+}
+
+// Contains reports whether t is in the window.
+func (w Window) Contains(t time.Time) bool {
+	if w.IsZero() {
+		return true
+	}
+	offset := t.Sub(midnight(t))
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// NextStart returns when the window opens next after t.
+func (w Window) NextStart(t time.Time) time.Time {
+	start := midnight(t).Add(w.Start)
+	if !start.After(t) {
+		start = midnight(t).AddDate(0, 0, 1).Add(w.Start)
+	}
+	return start
+}
+
+func (w Window) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+func midnight(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// inCompressWindow reports whether archives may be processed now.
+func (a *Appender) inCompressWindow() bool {
+	return a.opts.CompressWindow.Contains(a.opts.Clock())
+}
+
+// scheduleCompression processes the archives left unprocessed outside
+// Options.CompressWindow whenever it opens, until the Appender is closed.
+func (a *Appender) scheduleCompression() {
+	for {
+		now := a.opts.Clock()
+		wait := a.opts.CompressWindow.NextStart(now).Sub(now)
+		select {
+		case <-a.done:
+			return
+		case <-time.After(wait):
+		}
+
+		a.mu.Lock()
+		if a.closed {
+			a.mu.Unlock()
+			return
+		}
+		a.log(LevelInfo, "compress_window", Fields{"file": a.opts.Path, "window": a.opts.CompressWindow.String()},
+			"compression window", a.opts.CompressWindow, "opened, processing the pending archives")
+		a.resumePending()
+		a.mu.Unlock()
+	}
+}
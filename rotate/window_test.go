@@ -0,0 +1,118 @@
+package rotate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	for _, test := range []struct {
+		value      string
+		start, end time.Duration
+		ok         bool
+	}{
+		{"01:00-05:00", time.Hour, 5 * time.Hour, true},
+		{"1:30-5:45", 90 * time.Minute, 5*time.Hour + 45*time.Minute, true},
+		{"22:00-04:00", 22 * time.Hour, 4 * time.Hour, true},
+		{"23:59-00:00", 23*time.Hour + 59*time.Minute, 0, true},
+		{"00:00-24:00", 0, 24 * time.Hour, true},
+		{"", 0, 0, false},
+		{"01:00", 0, 0, false},
+		{"1-5", 0, 0, false},
+		{"night", 0, 0, false},
+		{"25:00-04:00", 0, 0, false},
+		{"24:30-04:00", 0, 0, false},
+		{"01:60-04:00", 0, 0, false},
+		{"-1:00-04:00", 0, 0, false},
+		{"03:00-03:00", 0, 0, false},
+	} {
+		w, err := ParseWindow(test.value)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("ParseWindow(%q) = %v, want an error", test.value, w)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWindow(%q): %v", test.value, err)
+			continue
+		}
+		if w.Start != test.start || w.End != test.end {
+			t.Errorf("ParseWindow(%q) = %v to %v, want %v to %v", test.value, w.Start, w.End, test.start, test.end)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2020, 1, 2, hour, minute, 0, 0, time.Local)
+	}
+	for _, test := range []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"01:00-05:00", at(0, 59), false},
+		{"01:00-05:00", at(1, 0), true},
+		{"01:00-05:00", at(4, 59), true},
+		{"01:00-05:00", at(5, 0), false},
+		{"22:00-04:00", at(21, 59), false},
+		{"22:00-04:00", at(22, 0), true},
+		{"22:00-04:00", at(23, 59), true},
+		{"22:00-04:00", at(0, 0), true},
+		{"22:00-04:00", at(3, 59), true},
+		{"22:00-04:00", at(4, 0), false},
+		{"22:00-04:00", at(12, 0), false},
+	} {
+		w, err := ParseWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Contains(test.t); got != test.want {
+			t.Errorf("%v Contains %s = %v, want %v", w, test.t.Format("15:04"), got, test.want)
+		}
+	}
+	if !(Window{}).Contains(at(12, 0)) {
+		t.Error("zero Window is closed, want it always open")
+	}
+}
+
+func TestWindowNextStart(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2020, 1, day, hour, minute, 0, 0, time.Local)
+	}
+	for _, test := range []struct {
+		window string
+		t      time.Time
+		want   time.Time
+	}{
+		{"01:00-05:00", at(2, 0, 30), at(2, 1, 0)},
+		{"01:00-05:00", at(2, 1, 0), at(3, 1, 0)},
+		{"01:00-05:00", at(2, 12, 0), at(3, 1, 0)},
+		{"22:00-04:00", at(2, 2, 0), at(2, 22, 0)},
+		{"22:00-04:00", at(2, 23, 0), at(3, 22, 0)},
+	} {
+		w, err := ParseWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.NextStart(test.t); !got.Equal(test.want) {
+			t.Errorf("%v NextStart %v = %v, want %v", w, test.t, got, test.want)
+		}
+	}
+}
+
+func TestWindowString(t *testing.T) {
+	for _, value := range []string{"01:00-05:00", "22:30-04:15", "00:00-24:00"} {
+		w, err := ParseWindow(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.String(); got != value {
+			t.Errorf("ParseWindow(%q).String() = %q", value, got)
+		}
+	}
+	if got, want := (Window{Start: 90 * time.Minute, End: 5 * time.Hour}).String(), "01:30-05:00"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
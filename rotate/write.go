@@ -0,0 +1,59 @@
+package rotate
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"time"
+)
+
+// DefaultRetryDelay is the delay before the first retry of a failed write.
+const DefaultRetryDelay = 100 * time.Millisecond
+
+// fileWriter writes to the Appender's current file, retrying failed writes
+// with an exponential backoff. It counts only the bytes that actually made
+// it to the file, so the rotation size stays accurate after errors.
+type fileWriter struct {
+	a *Appender
+}
+
+func (w fileWriter) Write(p []byte) (int, error) {
+	a := w.a
+	written := 0
+	delay := a.opts.RetryDelay
+	var out io.Writer = a.file
+	if a.encryptor != nil {
+		out = a.encryptor
+	}
+	if a.direct != nil {
+		out = a.direct
+	}
+	for attempt := 0; ; attempt++ {
+		n, err := out.Write(p[written:])
+		written += n
+		a.bytesWritten += n
+		if err == nil {
+			return written, nil
+		}
+
+		a.metrics.Add("write_errors", 1)
+		if attempt >= a.opts.WriteRetries {
+			return written, err
+		}
+
+		if IsNoSpace(err) && a.opts.PurgeOnFull {
+			a.purgeOldest("disk full")
+		}
+		a.log(LevelWarn, "write_retry", Fields{"file": a.filePath, "error": err.Error(), "attempt": attempt + 1},
+			"cannot write to", a.filePath, "retrying in", delay, "after:", err)
+		a.metrics.Add("write_retries", 1)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// IsNoSpace reports whether err, e.g. returned by Append, means that the
+// disk or the quota is full.
+func IsNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
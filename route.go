@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// parseRoutes parses --route into the files for the values of
+// --route-json-field.
+func parseRoutes(value string) (map[string]string, error) {
+	routes := map[string]string{}
+	for _, elem := range strings.Split(value, ",") {
+		if elem == "" {
+			continue
+		}
+		i := strings.Index(elem, "=")
+		if i <= 0 || i == len(elem)-1 {
+			return nil, fmt.Errorf("--route %q is not value=file", elem)
+		}
+		routes[elem[:i]] = elem[i+1:]
+	}
+	if len(routes) > 0 && *routeField == "" {
+		return nil, fmt.Errorf("--route requires --route-json-field")
+	}
+	return routes, nil
+}
+
+// openRoutes opens a rotating file for every value of --route and for
+// --json-invalid.
+func (s *pipeline) openRoutes() {
+	routes, err := parseRoutes(*routeFiles)
+	if err != nil {
+		logFatal(err)
+	}
+
+	// Lines routed to the same file share its Appender.
+	opened := map[string]*rotate.Appender{*outputFile: s.appender}
+	open := func(fileName string) *rotate.Appender {
+		a, ok := opened[fileName]
+		if !ok {
+			opts := appenderOptions(fileName)
+			opts.Metrics = s.metrics
+			if a, err = rotate.New(opts); err != nil {
+				logFatal(err)
+			}
+			opened[fileName] = a
+		}
+		return a
+	}
+	if len(routes) > 0 {
+		s.routes = map[string]*rotate.Appender{}
+	}
+	for value, fileName := range routes {
+		s.routes[value] = open(fileName)
+	}
+	if *jsonInvalid != "" {
+		s.invalidJSON = open(*jsonInvalid)
+	}
+}
+
+// routeAppenders returns the files of --route and --json-invalid besides
+// the output, each once.
+func (s *pipeline) routeAppenders() []*rotate.Appender {
+	var appenders []*rotate.Appender
+	seen := map[*rotate.Appender]bool{s.appender: true}
+	all := []*rotate.Appender{s.invalidJSON}
+	for _, a := range s.routes {
+		all = append(all, a)
+	}
+	for _, a := range all {
+		if a != nil && !seen[a] {
+			seen[a] = true
+			appenders = append(appenders, a)
+		}
+	}
+	return appenders
+}
+
+// route returns the file for line, the one --route maps the value of its
+// --route-json-field to or the output. Lines that are neither JSON objects
+// nor logfmt go to the output.
+func (s *pipeline) route(line []byte) *rotate.Appender {
+	if s.routes == nil {
+		return s.appender
+	}
+
+	value, ok := routeValue(line)
+	if !ok {
+		return s.appender
+	}
+	a, ok := s.routes[value]
+	if !ok {
+		return s.appender
+	}
+	s.metrics.Add("routed."+value, 1)
+	return a
+}
+
+// routeValue returns the value of the --route-json-field of line, a JSON
+// object or else logfmt.
+func routeValue(line []byte) (string, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return logfmtValue(line, *routeField)
+	}
+	raw, ok := fields[*routeField]
+	if !ok {
+		return "", false
+	}
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		return str, true
+	}
+	return string(raw), true
+}
+
+// closeRoutes closes the files of --route and --json-invalid.
+func (s *pipeline) closeRoutes() {
+	for _, a := range s.routeAppenders() {
+		a.Close()
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// TestRoutesDoNotAnnounceRotations checks --rotate-marker only tells about
+// rotations of the output, not of the file of --json-invalid.
+func TestRoutesDoNotAnnounceRotations(t *testing.T) {
+	dir := t.TempDir()
+	marker := path.Join(dir, "marker")
+	defer func(marker, invalid string) { *rotateMarker, *jsonInvalid = marker, invalid }(*rotateMarker, *jsonInvalid)
+	*rotateMarker, *jsonInvalid = marker, path.Join(dir, "invalid.log")
+
+	p := &pipeline{metrics: rotate.NewMetrics()}
+	p.openRoutes()
+	defer p.invalidJSON.Close()
+	if err := p.invalidJSON.Append("{"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.invalidJSON.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("rotating --json-invalid wrote %s: %v", marker, err)
+	}
+}
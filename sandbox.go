@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// sandboxEnv marks the process started again by --sandbox, which is
+// already confined.
+const sandboxEnv = "_STDIN_ROTATE_SANDBOXED"
+
+// sandboxPath is a file or directory the process confined by --sandbox may
+// still use, read only or also to create, write, rename and remove files in
+// it.
+type sandboxPath struct {
+	path  string
+	write bool
+}
+
+// sandboxSystemPaths are read, and executed from, for the libraries, the
+// binaries like gpg, age and zstd and the configuration of the system.
+var sandboxSystemPaths = []string{"/usr", "/bin", "/sbin", "/lib", "/lib64", "/etc", "/proc", "/dev", "/run"}
+
+// sandboxDevices are written, e.g. by the commands started for gpg.
+var sandboxDevices = []string{os.DevNull}
+
+// sandbox confines the process with --sandbox to the files and directories
+// it needs, so a producer exploiting it with hostile input cannot use its
+// permissions anywhere else. Only the calling thread can be confined, so it
+// confines the thread and starts the program again from it, which confines
+// the whole process. It returns in the confined process.
+func sandbox() {
+	if !*sandboxed {
+		return
+	}
+	if os.Getenv(sandboxEnv) != "" {
+		os.Unsetenv(sandboxEnv)
+		return
+	}
+	if flag.NArg() > 0 {
+		log.Fatalln("ERROR: --sandbox cannot be used with a command, which would be confined as well")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalln("ERROR: cannot sandbox:", err)
+	}
+	runtime.LockOSThread()
+	if err := confine(sandboxPaths(executable)); err != nil {
+		log.Fatalln("ERROR: cannot sandbox:", err)
+	}
+	err = syscall.Exec(executable, os.Args, append(os.Environ(), sandboxEnv+"=1"))
+	log.Fatalln("ERROR: cannot sandbox:", err)
+}
+
+// sandboxPaths returns what the process needs with the flags given: the
+// directories of the files it writes, which includes the archives, and the
+// files it reads.
+func sandboxPaths(executable string) []sandboxPath {
+	paths := []sandboxPath{{path: executable}}
+	for _, p := range sandboxSystemPaths {
+		paths = append(paths, sandboxPath{path: p})
+	}
+	for _, p := range sandboxDevices {
+		paths = append(paths, sandboxPath{path: p, write: true})
+	}
+	writeDir := func(fileName string) {
+		if fileName != "" {
+			paths = append(paths, sandboxPath{path: path.Dir(fileName), write: true})
+		}
+	}
+
+	var outputs []string
+	if !*noFile {
+		outputs = append(outputs, *outputFile)
+		if *outputSymlink == "follow" {
+			if target, err := rotate.SymlinkTarget(*outputFile); err == nil {
+				outputs = append(outputs, target)
+			}
+		}
+	}
+	for _, fileName := range append(outputs, *fallbackOutput, *stderrOutput, *jsonInvalid,
+		*pidfile, *logFile, *statsFile, *rotateMarker, *rotateFIFO) {
+		writeDir(fileName)
+	}
+	routes, _ := parseRoutes(*routeFiles)
+	for _, fileName := range routes {
+		writeDir(fileName)
+	}
+	// Sockets are created and removed next to their path.
+	for _, in := range append(splitInputs(*listenUnix), splitInputs(*listenUnixgram)...) {
+		writeDir(in.addr)
+	}
+	for _, in := range splitInputs(*inputFIFO) {
+		if in.addr != "-" {
+			paths = append(paths, sandboxPath{path: in.addr})
+		}
+	}
+	// Followed files are opened again after renames.
+	for _, in := range splitInputs(*followFile) {
+		paths = append(paths, sandboxPath{path: path.Dir(in.addr)})
+	}
+	if *configFile != "" {
+		paths = append(paths, sandboxPath{path: *configFile})
+	}
+	if *encryptRcpt != "" && !strings.HasPrefix(*encryptRcpt, "age1") {
+		// gpg keeps its trust database and locks in its home.
+		home := os.Getenv("GNUPGHOME")
+		if home == "" {
+			home = filepath.Join(os.Getenv("HOME"), ".gnupg")
+		}
+		paths = append(paths, sandboxPath{path: home, write: true})
+	}
+	for _, p := range strings.Split(*sandboxAllow, ",") {
+		if p != "" {
+			paths = append(paths, sandboxPath{path: p, write: true})
+		}
+	}
+	return paths
+}
+
+// checkSeccomp validates the flags of --seccomp, which does not allow
+// starting the programs for encryption and zstd.
+func checkSeccomp() error {
+	switch {
+	case !*seccomp:
+		return nil
+	case *encryptRcpt != "":
+		return fmt.Errorf("--seccomp cannot be used with --encrypt-recipient, which starts age or gpg")
+	case *inputCompress == "zstd", *inputCompress == "auto":
+		return fmt.Errorf("--seccomp cannot be used with --input-compression %s, which starts zstd, use gzip or none", *inputCompress)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The system calls and flags of Landlock, see landlock(7).
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1
+	landlockRulePathBeneath      = 1
+
+	landlockExecute    = 1 << 0
+	landlockWriteFile  = 1 << 1
+	landlockReadFile   = 1 << 2
+	landlockReadDir    = 1 << 3
+	landlockRemoveDir  = 1 << 4
+	landlockRemoveFile = 1 << 5
+	landlockMakeChar   = 1 << 6
+	landlockMakeDir    = 1 << 7
+	landlockMakeReg    = 1 << 8
+	landlockMakeSock   = 1 << 9
+	landlockMakeFifo   = 1 << 10
+	landlockMakeBlock  = 1 << 11
+	landlockMakeSym    = 1 << 12
+	landlockRefer      = 1 << 13
+	landlockTruncate   = 1 << 14
+
+	// landlockFileAccess are the rights that apply to files, not only to
+	// directories.
+	landlockFileAccess = landlockExecute | landlockWriteFile | landlockReadFile | landlockTruncate
+
+	prSetNoNewPrivs = 38
+	oPath           = 0x200000
+)
+
+// landlockPathBeneath is struct landlock_path_beneath_attr, which is packed.
+type landlockPathBeneath struct {
+	allowedAccess uint64
+	parentFd      int32
+}
+
+// confine restricts the calling thread to paths with Landlock, read only or
+// with every right. Paths that do not exist are left out.
+func confine(paths []sandboxPath) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("Landlock is not supported by the kernel: %v", errno)
+	}
+
+	handled := uint64(landlockExecute | landlockWriteFile | landlockReadFile | landlockReadDir |
+		landlockRemoveDir | landlockRemoveFile | landlockMakeChar | landlockMakeDir | landlockMakeReg |
+		landlockMakeSock | landlockMakeFifo | landlockMakeBlock | landlockMakeSym)
+	if abi >= 2 {
+		handled |= landlockRefer
+	}
+	if abi >= 3 {
+		handled |= landlockTruncate
+	}
+	read := uint64(landlockExecute | landlockReadFile | landlockReadDir)
+
+	// Only handled_access_fs, understood by every version.
+	ruleset, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&handled)), unsafe.Sizeof(handled), 0)
+	if errno != 0 {
+		return fmt.Errorf("cannot create Landlock ruleset: %v", errno)
+	}
+	defer syscall.Close(int(ruleset))
+
+	for _, p := range paths {
+		access := read
+		if p.write {
+			access = handled
+		}
+		if err := addLandlockRule(int(ruleset), p.path, access); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("cannot set no_new_privs: %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(sysLandlockRestrictSelf, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("cannot enforce Landlock ruleset: %v", errno)
+	}
+	return nil
+}
+
+func addLandlockRule(ruleset int, fileName string, access uint64) error {
+	fd, err := syscall.Open(fileName, oPath|syscall.O_CLOEXEC, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open %s for Landlock: %v", fileName, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("cannot stat %s for Landlock: %v", fileName, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	rule := landlockPathBeneath{allowedAccess: access, parentFd: int32(fd)}
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(ruleset), landlockRulePathBeneath, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("cannot add Landlock rule for %s: %v", fileName, errno)
+	}
+	return nil
+}
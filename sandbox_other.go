@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func confine(paths []sandboxPath) error {
+	return errors.New("only supported on Linux")
+}
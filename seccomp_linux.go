@@ -0,0 +1,118 @@
+//go:build amd64 || arm64
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// The parts of seccomp(2) and classic BPF needed for a filter allowing a
+// list of system calls.
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+
+	seccompRetAllow = 0x7fff0000
+	seccompRetErrno = 0x00050000
+
+	bpfLd  = 0x00
+	bpfW   = 0x00
+	bpfAbs = 0x20
+	bpfJmp = 0x05
+	bpfJeq = 0x10
+	bpfJge = 0x30
+	bpfK   = 0x00
+	bpfRet = 0x06
+
+	// Offsets in struct seccomp_data.
+	seccompDataNr   = 0
+	seccompDataArch = 4
+)
+
+// seccompCommonSyscalls are the system calls the process needs in the
+// steady state, i.e. reading lines, writing, rotating and compressing
+// files, sending them over the network and the Go runtime and libc.
+var seccompCommonSyscalls = []uintptr{
+	// Files and pipes.
+	syscall.SYS_READ, syscall.SYS_WRITE, syscall.SYS_READV, syscall.SYS_WRITEV,
+	syscall.SYS_PREAD64, syscall.SYS_PWRITE64, syscall.SYS_OPENAT, syscall.SYS_CLOSE,
+	syscall.SYS_FSTAT, syscall.SYS_LSEEK, syscall.SYS_FCNTL,
+	syscall.SYS_DUP3, syscall.SYS_PIPE2, syscall.SYS_RENAMEAT, syscall.SYS_UNLINKAT,
+	syscall.SYS_FSYNC, syscall.SYS_FDATASYNC, syscall.SYS_FTRUNCATE, syscall.SYS_TRUNCATE,
+	syscall.SYS_FALLOCATE, syscall.SYS_FLOCK, syscall.SYS_GETDENTS64, syscall.SYS_READLINKAT,
+	syscall.SYS_FSTATFS, syscall.SYS_STATFS, syscall.SYS_FADVISE64, syscall.SYS_FCHMODAT,
+	syscall.SYS_MKNODAT, syscall.SYS_LINKAT,
+	// Sockets, for forwarding, reconnecting and accepting connections.
+	syscall.SYS_SOCKET, syscall.SYS_CONNECT, syscall.SYS_ACCEPT4, syscall.SYS_SENDTO,
+	syscall.SYS_SENDMSG, syscall.SYS_RECVFROM, syscall.SYS_RECVMSG,
+	syscall.SYS_SETSOCKOPT, syscall.SYS_GETSOCKOPT, syscall.SYS_GETSOCKNAME,
+	syscall.SYS_GETPEERNAME, syscall.SYS_SHUTDOWN, syscall.SYS_BIND, syscall.SYS_LISTEN,
+	syscall.SYS_PPOLL,
+	// Memory, threads, signals and time.
+	syscall.SYS_MMAP, syscall.SYS_MUNMAP, syscall.SYS_MPROTECT, syscall.SYS_MADVISE,
+	syscall.SYS_MREMAP, syscall.SYS_BRK, syscall.SYS_CLONE, syscall.SYS_FUTEX,
+	syscall.SYS_SET_ROBUST_LIST, syscall.SYS_RT_SIGACTION, syscall.SYS_RT_SIGPROCMASK,
+	syscall.SYS_RT_SIGRETURN, syscall.SYS_SIGALTSTACK, syscall.SYS_TGKILL, syscall.SYS_TKILL,
+	// Signals forwarded to the command.
+	syscall.SYS_KILL,
+	syscall.SYS_GETPID, syscall.SYS_GETTID, syscall.SYS_GETUID, syscall.SYS_GETEUID,
+	syscall.SYS_GETGID, syscall.SYS_GETEGID, syscall.SYS_UNAME, syscall.SYS_SCHED_YIELD,
+	syscall.SYS_SCHED_GETAFFINITY, syscall.SYS_NANOSLEEP, syscall.SYS_CLOCK_GETTIME,
+	syscall.SYS_CLOCK_NANOSLEEP, syscall.SYS_GETTIMEOFDAY, syscall.SYS_EPOLL_CREATE1,
+	syscall.SYS_EPOLL_CTL, syscall.SYS_EPOLL_PWAIT, syscall.SYS_EVENTFD2, syscall.SYS_PRLIMIT64,
+	syscall.SYS_GETRLIMIT, syscall.SYS_SETPRIORITY, syscall.SYS_IOPRIO_SET, syscall.SYS_WAIT4,
+	syscall.SYS_WAITID, syscall.SYS_TIMER_CREATE, syscall.SYS_TIMER_SETTIME,
+	syscall.SYS_TIMER_DELETE, syscall.SYS_SETITIMER, syscall.SYS_EXIT, syscall.SYS_EXIT_GROUP,
+}
+
+// installSeccomp allows the process only the system calls of
+// seccompCommonSyscalls and seccompArchSyscalls from now on. Others fail
+// with EPERM, so a bug or an exploit cannot start programs, change
+// permissions or owners or mount filesystems. The filter applies to all
+// threads of the process and the ones started later.
+func installSeccomp() error {
+	allowed := append(append([]uintptr{}, seccompCommonSyscalls...), seccompArchSyscalls...)
+	deny := uint32(seccompRetErrno | uint32(syscall.EPERM))
+	n := len(allowed)
+	if n > 255 {
+		return fmt.Errorf("too many system calls for the jumps of one filter")
+	}
+
+	program := []sockFilter{
+		{code: bpfLd | bpfW | bpfAbs, k: seccompDataArch},
+		{code: bpfJmp | bpfJeq | bpfK, jt: 1, k: seccompAuditArch},
+		{code: bpfRet | bpfK, k: deny},
+		{code: bpfLd | bpfW | bpfAbs, k: seccompDataNr},
+		// The numbers of another ABI of the architecture, like x32.
+		{code: bpfJmp | bpfJge | bpfK, jt: uint8(n), k: seccompABIBit},
+	}
+	for i, nr := range allowed {
+		program = append(program, sockFilter{code: bpfJmp | bpfJeq | bpfK, jt: uint8(n - i), k: uint32(nr)})
+	}
+	program = append(program, sockFilter{code: bpfRet | bpfK, k: deny}, sockFilter{code: bpfRet | bpfK, k: seccompRetAllow})
+
+	prog := sockFprog{len: uint16(len(program)), filter: &program[0]}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("cannot set no_new_privs: %v", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("cannot install seccomp filter: %v", errno)
+	}
+	return nil
+}
+
+// sockFilter is struct sock_filter, an instruction of classic BPF.
+type sockFilter struct {
+	code uint16
+	jt   uint8
+	jf   uint8
+	k    uint32
+}
+
+// sockFprog is struct sock_fprog.
+type sockFprog struct {
+	len    uint16
+	filter *sockFilter
+}
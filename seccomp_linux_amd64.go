@@ -0,0 +1,26 @@
+package main
+
+import "syscall"
+
+const (
+	sysSeccomp       = 317
+	seccompAuditArch = 0xc000003e
+	seccompABIBit    = 0x40000000
+)
+
+// seccompArchSyscalls are the system calls of seccompCommonSyscalls only
+// amd64 has, or that are newer than the syscall package.
+var seccompArchSyscalls = []uintptr{
+	syscall.SYS_OPEN, syscall.SYS_STAT, syscall.SYS_LSTAT, syscall.SYS_RENAME,
+	syscall.SYS_UNLINK, syscall.SYS_READLINK, syscall.SYS_PIPE, syscall.SYS_DUP2,
+	syscall.SYS_POLL, syscall.SYS_EPOLL_WAIT, syscall.SYS_ARCH_PRCTL, syscall.SYS_ACCESS,
+	syscall.SYS_GETDENTS, syscall.SYS_TIME, syscall.SYS_NEWFSTATAT,
+	307, // sendmmsg
+	316, // renameat2
+	318, // getrandom
+	332, // statx
+	334, // rseq
+	424, // pidfd_send_signal
+	435, // clone3
+	441, // epoll_pwait2
+}
@@ -0,0 +1,24 @@
+package main
+
+import "syscall"
+
+const (
+	sysSeccomp       = 277
+	seccompAuditArch = 0xc00000b7
+	// arm64 has no other ABI, no system call has this number.
+	seccompABIBit = 0x40000000
+)
+
+// seccompArchSyscalls are the system calls of seccompCommonSyscalls only
+// arm64 has, or that are newer than the syscall package.
+var seccompArchSyscalls = []uintptr{
+	syscall.SYS_FSTATAT,
+	269, // sendmmsg
+	276, // renameat2
+	278, // getrandom
+	291, // statx
+	293, // rseq
+	424, // pidfd_send_signal
+	435, // clone3
+	441, // epoll_pwait2
+}
@@ -0,0 +1,9 @@
+//go:build !linux || !(amd64 || arm64)
+
+package main
+
+import "errors"
+
+func installSeccomp() error {
+	return errors.New("only supported on Linux on amd64 and arm64")
+}
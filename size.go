@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sizeUnits are the suffixes a size flag accepts, as powers of 1024.
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// sizeFlag is an int flag of bytes that also accepts the units K, M, G and
+// T, e.g. 100M, optionally followed by B.
+type sizeFlag int
+
+// sizeVar defines a size flag like flag.Int.
+func sizeVar(name string, value int, usage string) *int {
+	p := new(int)
+	*p = value
+	flag.Var((*sizeFlag)(p), name, usage)
+	return p
+}
+
+func (s *sizeFlag) String() string {
+	return formatSize(int64(*s))
+}
+
+func (s *sizeFlag) Set(value string) error {
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(size)
+	return nil
+}
+
+// parseSize parses a number of bytes with an optional unit.
+func parseSize(value string) (int, error) {
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, factor = strings.TrimSuffix(number, unit.suffix), unit.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(number), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid size, expected bytes or a number with K, M, G or T")
+	}
+	if n < 0 {
+		return 0, errors.New("size must not be negative")
+	}
+	size := n * factor
+	if size/factor != n || int64(int(size)) != size {
+		return 0, errors.New("size is too large")
+	}
+	return int(size), nil
+}
+
+// rateFlag is a size flag of bytes per second, which may end with /s as in
+// 10MB/s.
+type rateFlag int
+
+// rateVar defines a rate flag like flag.Int.
+func rateVar(name string, value int, usage string) *int {
+	p := new(int)
+	*p = value
+	flag.Var((*rateFlag)(p), name, usage)
+	return p
+}
+
+func (r *rateFlag) String() string {
+	return formatSize(int64(*r)) + "/s"
+}
+
+func (r *rateFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if strings.HasSuffix(strings.ToLower(value), "/s") {
+		value = value[:len(value)-2]
+	}
+	size, err := parseSize(value)
+	if err != nil {
+		return err
+	}
+	*r = rateFlag(size)
+	return nil
+}
+
+// ageFlag is a duration flag that also accepts whole days, e.g. 14d.
+type ageFlag time.Duration
+
+// ageVar defines an age flag like flag.Duration.
+func ageVar(name string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	*p = value
+	flag.Var((*ageFlag)(p), name, usage)
+	return p
+}
+
+func (f *ageFlag) String() string {
+	d := time.Duration(*f)
+	if d > 0 && d%(24*time.Hour) == 0 {
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	}
+	return d.String()
+}
+
+func (f *ageFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	var d time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return errors.New("invalid age, expected a number of days like 14d or a duration like 36h")
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(value); err != nil {
+			return errors.New("invalid age, expected a number of days like 14d or a duration like 36h")
+		}
+	}
+	if d < 0 {
+		return errors.New("age must not be negative")
+	}
+	*f = ageFlag(d)
+	return nil
+}
+
+// formatSize returns size with the largest unit it is a multiple of.
+func formatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if size != 0 && size%unit.factor == 0 {
+			return strconv.FormatInt(size/unit.factor, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10)
+}
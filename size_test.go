@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		size  int64
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"1234", 1234, true},
+		{"10K", 10 << 10, true},
+		{"10k", 10 << 10, true},
+		{"10KB", 10 << 10, true},
+		{"10kb", 10 << 10, true},
+		{"100M", 100 << 20, true},
+		{"5G", 5 << 30, strconv.IntSize == 64},
+		{"2T", 2 << 40, strconv.IntSize == 64},
+		{" 7 M ", 7 << 20, true},
+		{"1B", 1, true},
+		{"", 0, false},
+		{"M", 0, false},
+		{"1.5M", 0, false},
+		{"10P", 0, false},
+		{"ten", 0, false},
+		{"10 MiB", 0, false},
+		{"-1", 0, false},
+		{"-1K", 0, false},
+		{"9223372036854775807", 9223372036854775807, strconv.IntSize == 64},
+		{"9223372036854775808", 0, false},
+		{"8388608T", 0, false},
+	} {
+		size, err := parseSize(test.value)
+		if test.ok && (err != nil || int64(size) != test.size) {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", test.value, size, err, test.size)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("parseSize(%q) = %d, want an error", test.value, size)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for _, test := range []struct {
+		size int64
+		want string
+	}{
+		{0, "0"},
+		{1000, "1000"},
+		{1024, "1K"},
+		{1536, "1536"},
+		{10 << 20, "10M"},
+		{3 << 40, "3T"},
+	} {
+		if got := formatSize(test.size); got != test.want {
+			t.Errorf("formatSize(%d) = %q, want %q", test.size, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// statsReporter periodically writes a single logfmt line with the deltas of
+// all counters since the previous line, e.g.
+//
+//	ts=2017-06-01T12:00:00Z interval=60s bytes=1048576 lines=8192 rotations=1 lines_per_sec=136.5
+type statsReporter struct {
+	out     io.Writer
+	metrics *rotate.Metrics
+
+	last     map[string]int64
+	lastTime time.Time
+}
+
+func newStatsReporter(out io.Writer, metrics *rotate.Metrics) *statsReporter {
+	return &statsReporter{
+		out:      out,
+		metrics:  metrics,
+		last:     make(map[string]int64),
+		lastTime: time.Now(),
+	}
+}
+
+func (r *statsReporter) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.report()
+	}
+}
+
+func (r *statsReporter) report() {
+	now := time.Now()
+	counters, _ := r.metrics.Snapshot()
+	elapsed := now.Sub(r.lastTime)
+
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "ts=%s interval=%s", now.UTC().Format(time.RFC3339), elapsed.Round(time.Second))
+	for _, name := range sortedKeys(counters) {
+		fmt.Fprintf(&line, " %s=%d", name, counters[name]-r.last[name])
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		fmt.Fprintf(&line, " lines_per_sec=%.1f bytes_per_sec=%.1f",
+			float64(counters["lines"]-r.last["lines"])/seconds,
+			float64(counters["bytes"]-r.last["bytes"])/seconds)
+	}
+	line.WriteByte('\n')
+
+	r.last, r.lastTime = counters, now
+	if _, err := r.out.Write(line.Bytes()); err != nil {
+		logError("cannot write stats:", err)
+	}
+}
+
+// sortedKeys returns the names of counters in lexical order, so reports are
+// stable between runs.
+func sortedKeys(counters map[string]int64) []string {
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// registerCounters adds the counters of the lines every enabled rule passes,
+// filters or drops at zero, so reports list them before the first line is
+// dropped and the counts of a run add up to the lines read.
+func (s *pipeline) registerCounters() {
+	names := []string{"lines", "lines_lost"}
+	if s.syslog != nil {
+		names = append(names, "syslog.lines", "syslog.filtered", "syslog.dropped")
+	}
+	if s.kafka != nil {
+		names = append(names, "kafka.lines", "kafka.filtered", "kafka.dropped")
+	}
+	if s.gelf != nil {
+		names = append(names, "gelf.lines", "gelf.filtered", "gelf.dropped", "gelf.errors")
+	}
+	if s.forward != nil {
+		names = append(names, "forward.lines", "forward.dropped", "forward.errors", "forward.lost")
+	}
+	if s.fluentd != nil {
+		names = append(names, "fluentd.lines", "fluentd.dropped", "fluentd.errors", "fluentd.lost")
+	}
+	if s.shipper != nil {
+		names = append(names, "http.lines", "http.requests", "http.dropped", "http.errors", "http.lost", "http.rejected")
+	}
+	if s.journal != nil {
+		names = append(names, "journald.lines", "journald.filtered", "journald.errors")
+	}
+	if s.flushRegexp != nil {
+		names = append(names, "flush.matched", "syncs")
+	}
+	if s.queue != nil {
+		names = append(names, "queue.full")
+	}
+	if *stripANSI {
+		names = append(names, "ansi.stripped")
+	}
+	if metric, ok := controlMetrics[*controlChars]; ok {
+		names = append(names, "control."+metric)
+	}
+	if *jsonCompact || *jsonSortKeys {
+		names = append(names, "json.invalid")
+	}
+	if *compressOld {
+		names = append(names, "compress_verify_failed")
+	}
+	if *heartbeat > 0 {
+		names = append(names, "heartbeats")
+	}
+	for _, name := range names {
+		s.metrics.Add(name, 0)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// statsdMaxPacket keeps packets below the usual Ethernet MTU.
+const statsdMaxPacket = 1432
+
+// statsdReporter periodically pushes the deltas of the metrics as statsd
+// counters and timers. Tags are appended in the DogStatsD format.
+type statsdReporter struct {
+	conn    net.Conn
+	prefix  string
+	tags    string
+	metrics *rotate.Metrics
+
+	lastCounters map[string]int64
+	lastTimings  map[string]rotate.Timing
+}
+
+func newStatsdReporter(target, prefix, tags string, metrics *rotate.Metrics) (*statsdReporter, error) {
+	conn, err := net.Dial("udp", target)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &statsdReporter{
+		conn:         conn,
+		prefix:       prefix,
+		metrics:      metrics,
+		lastCounters: make(map[string]int64),
+		lastTimings:  make(map[string]rotate.Timing),
+	}
+	if tags != "" {
+		r.tags = "|#" + tags
+	}
+	return r, nil
+}
+
+func (r *statsdReporter) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.report()
+	}
+}
+
+func (r *statsdReporter) report() {
+	counters, timings := r.metrics.Snapshot()
+
+	lines := []string{}
+	for _, name := range sortedKeys(counters) {
+		delta := counters[name] - r.lastCounters[name]
+		if delta != 0 {
+			lines = append(lines, fmt.Sprintf("%s%s:%d|c%s", r.prefix, name, delta, r.tags))
+		}
+	}
+
+	// Timers are reported as the average of the events since the last report.
+	timingNames := []string{}
+	for name := range timings {
+		timingNames = append(timingNames, name)
+	}
+	sort.Strings(timingNames)
+	for _, name := range timingNames {
+		t, last := timings[name], r.lastTimings[name]
+		if count := t.Count - last.Count; count > 0 {
+			avg := (t.Total - last.Total) / time.Duration(count)
+			ms := float64(avg) / float64(time.Millisecond)
+			lines = append(lines, fmt.Sprintf("%s%s:%.3f|ms%s", r.prefix, name, ms, r.tags))
+		}
+	}
+
+	r.lastCounters, r.lastTimings = counters, timings
+	r.send(lines)
+}
+
+// send packs lines into as few packets as possible.
+func (r *statsdReporter) send(lines []string) {
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			r.write(packet.Bytes())
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		r.write(packet.Bytes())
+	}
+}
+
+func (r *statsdReporter) write(packet []byte) {
+	if _, err := r.conn.Write(packet); err != nil {
+		logError("cannot send metrics to statsd:", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// failures records the failures of --strict for its summary on exit.
+var failures failureSummary
+
+// failureSummary counts failures by their class, keeping the first one of
+// each and the status of the first of all.
+type failureSummary struct {
+	mu      sync.Mutex
+	status  int
+	classes []*failureClass
+}
+
+type failureClass struct {
+	name   string
+	status int
+	count  int
+	first  string
+}
+
+// add records a failure of event, like "compress_failed", on fileName and
+// returns the status to exit with for it.
+func (f *failureSummary) add(event, fileName string, err error) int {
+	status, name := failureStatus(event, err)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status == 0 {
+		f.status = status
+	}
+	for _, class := range f.classes {
+		if class.name == name {
+			class.count++
+			return status
+		}
+	}
+	f.classes = append(f.classes, &failureClass{name: name, status: status, count: 1, first: event + " " + fileName + ": " + err.Error()})
+	return status
+}
+
+// failureStatus returns the exit status and the name of the class of a
+// failure of event with err. A full disk and missing permissions are told
+// apart whatever step they failed.
+func failureStatus(event string, err error) (int, string) {
+	switch {
+	case rotate.IsNoSpace(err):
+		return exitDiskFull, "disk full"
+	case errors.Is(err, os.ErrPermission):
+		return exitPermission, "permission denied"
+	}
+	switch event {
+	case "write_failed", "open_failed":
+		return exitWriteFailed, "write failed"
+	case "rotate_failed", "number_failed", "sequence_failed":
+		return exitRotateFailed, "rotation failed"
+	case "compress_failed", "encrypt_failed", "checksum_failed":
+		return exitCompressFailed, "compression failed"
+	case "delete_failed", "retention_failed":
+		return exitDeleteFailed, "deletion failed"
+	case "syslog_failed":
+		return exitSyslogFailed, "syslog failed"
+	}
+	return exitError, "other failure"
+}
+
+// print writes the summary to stderr for exiting with status. It returns
+// the status of the first failure instead of 0 if there was one.
+func (f *failureSummary) print(status int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if status == 0 {
+		status = f.status
+	}
+	if len(f.classes) == 0 {
+		fmt.Fprintf(os.Stderr, "strict: exiting with status %d, no failures\n", status)
+		return status
+	}
+	fmt.Fprintf(os.Stderr, "strict: exiting with status %d after these failures:\n", status)
+	for _, class := range f.classes {
+		fmt.Fprintf(os.Stderr, "  %s (status %d): %d, first %s\n", class.name, class.status, class.count, class.first)
+	}
+	return status
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log/syslog"
+	"sync"
+	"sync/atomic"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// syslogQueueSize is how many lines may wait to be sent to the syslog
+// server before further ones are dropped.
+const syslogQueueSize = 10000
+
+// syslogSender sends lines to the syslog server from its own goroutine, so
+// Append does not wait for a syscall for every matching line.
+type syslogSender struct {
+	writer  *syslog.Writer
+	health  *health
+	metrics *rotate.Metrics
+	// failed is called with the errors sending lines, if not nil.
+	failed func(error)
+
+	lines     chan syslogLine
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	dropped   int64
+}
+
+// syslogLine is a queued line with its severity, or -1 for the one of
+// --syslog-priority.
+type syslogLine struct {
+	text     []byte
+	severity int
+}
+
+func newSyslogSender(w *syslog.Writer, h *health, metrics *rotate.Metrics, failed func(error)) *syslogSender {
+	s := &syslogSender{
+		writer:  w,
+		health:  h,
+		metrics: metrics,
+		failed:  failed,
+		lines:   make(chan syslogLine, syslogQueueSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write queues a copy of line. It never blocks; lines are dropped if the
+// queue is full because the syslog server cannot keep up.
+func (s *syslogSender) Write(line []byte) (int, error) {
+	return s.WriteSeverity(line, -1)
+}
+
+// WriteSeverity queues line like Write to be sent with severity instead of
+// the one of --syslog-priority, unless it is -1.
+func (s *syslogSender) WriteSeverity(line []byte, severity int) (int, error) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case s.lines <- syslogLine{buf, severity}:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+		s.metrics.Add("syslog.dropped", 1)
+	}
+	return len(line), nil
+}
+
+func (s *syslogSender) run() {
+	defer close(s.done)
+	for line := range s.lines {
+		err := s.send(line)
+		s.health.sentSyslog(err)
+		if err != nil && s.failed != nil {
+			s.failed(err)
+		}
+		if dropped := atomic.SwapInt64(&s.dropped, 0); dropped > 0 {
+			logEvent(levelWarn, "syslog_dropped", logFields{"lines": dropped}, "syslog queue full, dropped", dropped, "lines")
+		}
+	}
+}
+
+// send sends line with its severity and the facility of --syslog-priority.
+func (s *syslogSender) send(line syslogLine) error {
+	text := string(line.text)
+	switch line.severity {
+	case 0:
+		return s.writer.Emerg(text)
+	case 1:
+		return s.writer.Alert(text)
+	case 2:
+		return s.writer.Crit(text)
+	case 3:
+		return s.writer.Err(text)
+	case 4:
+		return s.writer.Warning(text)
+	case 5:
+		return s.writer.Notice(text)
+	case 6:
+		return s.writer.Info(text)
+	case 7:
+		return s.writer.Debug(text)
+	}
+	_, err := s.writer.Write(line.text)
+	return err
+}
+
+// Close sends the remaining queued lines and closes the connection.
+// Calling it again waits for the first call to finish.
+func (s *syslogSender) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.lines)
+		<-s.done
+		s.closeErr = s.writer.Close()
+	})
+	return s.closeErr
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// systemdSources returns the sockets passed by systemd socket activation
+// as described in sd_listen_fds(3). The environment variables are removed,
+// so a command started with -- does not take them for its own.
+func systemdSources() ([]source, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	var sources []source
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - listenFDsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		syscall.CloseOnExec(fd)
+		src, err := systemdSource(os.NewFile(uintptr(fd), name))
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// systemdSource takes over the stream or datagram socket f.
+func systemdSource(f *os.File) (source, error) {
+	defer f.Close()
+
+	if l, err := net.FileListener(f); err == nil {
+		network := l.Addr().Network()
+		logDebug("using", network, "socket", f.Name(), "passed by systemd")
+		return func(s *pipeline) error { return s.serveConns(l, network, "") }, nil
+	}
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use socket %s passed by systemd: %v", f.Name(), err)
+	}
+	network := conn.LocalAddr().Network()
+	if err := enableDropCount(conn.(syscall.Conn)); err != nil {
+		logWarn("cannot count dropped", network, "datagrams:", err)
+	}
+	logDebug("using", network, "socket", f.Name(), "passed by systemd")
+	return func(s *pipeline) error { return s.serveDatagrams(conn, network, "") }, nil
+}
+
+// notifySocket is the socket of sd_notify(3) systemd passed, empty if it
+// did not.
+var notifySocket string
+
+// sdNotify sends state like READY=1 to systemd, if it asked for it with
+// Type=notify.
+func sdNotify(state string) {
+	if notifySocket == "" {
+		return
+	}
+	name := notifySocket
+	if name[0] == '@' {
+		// An abstract socket.
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		logWarn("cannot notify systemd:", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		logWarn("cannot notify systemd:", err)
+	}
+}
+
+// startNotify takes over the environment variables of sd_notify(3), so a
+// command started with -- does not take them for its own, and pings the
+// watchdog of WatchdogSec= while writing works.
+func (s *pipeline) startNotify() {
+	notifySocket = os.Getenv("NOTIFY_SOCKET")
+	usec, _ := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID"))
+	if err != nil {
+		pid = os.Getpid()
+	}
+	os.Unsetenv("NOTIFY_SOCKET")
+	os.Unsetenv("WATCHDOG_USEC")
+	os.Unsetenv("WATCHDOG_PID")
+	if notifySocket == "" || usec <= 0 || pid != os.Getpid() {
+		return
+	}
+
+	timeout := time.Duration(usec) * time.Microsecond
+	logDebug("pinging the systemd watchdog every", timeout/2)
+	go func() {
+		for range time.Tick(timeout / 2) {
+			if waiting := s.health.waiting(); waiting >= timeout/2 {
+				// Let systemd restart us, the output is wedged.
+				logEvent(levelError, "watchdog_missed", logFields{"file": *outputFile, "waiting_seconds": waiting.Seconds()},
+					"not pinging the systemd watchdog, no line could be written for", waiting.Round(time.Millisecond))
+				continue
+			}
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/innogames/stdin-rotate/rotate"
+)
+
+// runTail prints the last lines of the output like tail. With -f it keeps
+// printing the lines appended to it, following the new file whenever the
+// output is rotated.
+func runTail(args []string) int {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	output := fs.String("output", "./output.log", "Output file to print the last lines of")
+	lines := fs.Int("n", 10, "Number of last lines to print")
+	followFlag := fs.Bool("f", false, "Keep printing the lines appended to --output across rotations")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "USAGE:\n\tstdin-rotate tail [FLAGS]\n\t\tprints the last lines of --output\n\nFLAGS:\n")
+		fs.PrintDefaults()
+	}
+	if args = parseSubcommand(fs, args); len(args) != 0 || *lines < 0 {
+		fs.Usage()
+		return 2
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+	// Tell the archives rotated while following from the older ones.
+	known, _ := archiveNames(*output)
+	f := &follower{path: *output}
+	defer f.close()
+	if err := f.open(io.SeekStart); err != nil && !*followFlag {
+		log.Println("ERROR:", err)
+		return 1
+	}
+
+	// Read the whole file keeping its last lines.
+	last := make([]string, 0, *lines)
+	f.emit = func(line string) {
+		if *lines <= 0 {
+			return
+		}
+		if len(last) == *lines {
+			last = append(last[:0], last[1:]...)
+		}
+		last = append(last, line)
+	}
+	never := func() bool { return false }
+	if f.file != nil {
+		f.readLines(never)
+	}
+	for _, line := range last {
+		fmt.Fprintln(stdout, line)
+	}
+	if !*followFlag {
+		if f.partial != "" {
+			fmt.Fprintln(stdout, f.partial)
+		}
+		return 0
+	}
+	stdout.Flush()
+
+	f.emit = func(line string) {
+		fmt.Println(line)
+	}
+	f.run(never, func(change fileChange) {
+		if change == fileReplaced {
+			known = printSkippedArchives(*output, known)
+		}
+	})
+	return 0
+}
+
+// archiveNames returns the names of the archives of output without the
+// extensions of compression and encryption, which change after rotation.
+func archiveNames(output string) (map[string]bool, []string) {
+	archives, _ := rotate.Archives(output)
+	names := map[string]bool{}
+	for _, fileName := range archives {
+		names[archiveName(fileName)] = true
+	}
+	return names, archives
+}
+
+func archiveName(fileName string) string {
+	for _, ext := range []string{".age", ".gpg", ".gz", ".zst"} {
+		fileName = strings.TrimSuffix(fileName, ext)
+	}
+	return fileName
+}
+
+// printSkippedArchives prints the archives rotated after the one of the
+// file that was followed, for rotations faster than the output is checked.
+// known are the archives that existed when the file was opened. It returns
+// the archives that exist now.
+func printSkippedArchives(output string, known map[string]bool) map[string]bool {
+	names, archives := archiveNames(output)
+	followed := false
+	for _, fileName := range archives {
+		if known[archiveName(fileName)] {
+			continue
+		}
+		if !followed {
+			followed = true
+			continue
+		}
+		r, err := rotate.OpenArchive(fileName)
+		if os.IsNotExist(err) {
+			// It was compressed in the meantime.
+			r, err = rotate.OpenArchive(fileName + ".gz")
+		}
+		if err != nil {
+			log.Println("ERROR:", err)
+			continue
+		}
+		io.Copy(os.Stdout, r)
+		r.Close()
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// transform applies --strip-ansi, --control-chars and the JSON
+// normalization to line, in buffers reused for the next line. It reports
+// false for lines that are not valid JSON while normalizing. It needs s.mu.
+func (s *pipeline) transform(line []byte) ([]byte, bool) {
+	if *stripANSI && bytes.IndexByte(line, esc) >= 0 {
+		s.transformed = appendStrippedANSI(s.transformed[:0], line)
+		line = s.transformed
+		s.metrics.Add("ansi.stripped", 1)
+	}
+	if *controlChars != "keep" && hasControl(line) {
+		s.escaped = appendControl(s.escaped[:0], line, *controlChars == "escape")
+		line = s.escaped
+		s.metrics.Add("control."+controlMetrics[*controlChars], 1)
+	}
+	if *jsonCompact || *jsonSortKeys {
+		if err := s.normalizeJSON(line); err != nil {
+			s.metrics.Add("json.invalid", 1)
+			return line, false
+		}
+		line = s.normalized.Bytes()
+	}
+	return line, true
+}
+
+// normalizeJSON writes line compacted to s.normalized, with the keys of its
+// objects sorted with --json-sort-keys. Numbers are kept as they are.
+func (s *pipeline) normalizeJSON(line []byte) error {
+	s.normalized.Reset()
+	if !*jsonSortKeys {
+		return json.Compact(&s.normalized, line)
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("data after JSON value")
+	}
+	enc := json.NewEncoder(&s.normalized)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode appends a newline.
+	s.normalized.Truncate(s.normalized.Len() - 1)
+	return nil
+}
+
+// controlMetrics name the counters of the lines changed by the values of
+// --control-chars.
+var controlMetrics = map[string]string{
+	"escape": "escaped",
+	"drop":   "dropped",
+}
+
+// isControl reports whether c is a control character a terminal would act
+// on, which are all but tab.
+func isControl(c byte) bool {
+	return c < 0x20 && c != '\t' || c == 0x7f
+}
+
+func hasControl(line []byte) bool {
+	for _, c := range line {
+		if isControl(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendControl appends line to dst with its control characters escaped
+// like \x07, or without them if escape is false.
+func appendControl(dst, line []byte, escape bool) []byte {
+	const hex = "0123456789abcdef"
+	for _, c := range line {
+		switch {
+		case !isControl(c):
+			dst = append(dst, c)
+		case escape:
+			dst = append(dst, '\\', 'x', hex[c>>4], hex[c&0xf])
+		}
+	}
+	return dst
+}
+
+const (
+	esc = 0x1b
+	bel = 0x07
+)
+
+// appendStrippedANSI appends line to dst without the escape sequences of
+// terminals: CSI sequences like colors and cursor movements, OSC sequences
+// like window titles and hyperlinks, and the two and three byte ones.
+func appendStrippedANSI(dst, line []byte) []byte {
+	for i := 0; i < len(line); i++ {
+		if line[i] != esc {
+			dst = append(dst, line[i])
+			continue
+		}
+		if i+1 == len(line) {
+			break
+		}
+		i++
+		switch c := line[i]; {
+		case c == '[':
+			// Parameter and intermediate bytes up to the final byte.
+			for i++; i < len(line) && (line[i] < 0x40 || line[i] > 0x7e); i++ {
+			}
+		case c == ']':
+			// Up to BEL or ESC \.
+			for i++; i < len(line); i++ {
+				if line[i] == bel {
+					break
+				}
+				if line[i] == esc && i+1 < len(line) && line[i+1] == '\\' {
+					i++
+					break
+				}
+			}
+		case c >= 0x20 && c <= 0x2f:
+			// Designating character sets like ESC ( B, up to the final byte.
+			for ; i < len(line) && line[i] >= 0x20 && line[i] <= 0x2f; i++ {
+			}
+		}
+	}
+	return dst
+}